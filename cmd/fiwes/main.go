@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 // Actual version value will be set at build time
 var version = "0.0-dev"
 
+// shutdownTimeout holds time to finish active requests after stop signal
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	run(os.Exit)
 }
@@ -22,8 +31,34 @@ func run(exitFunc func(code int)) {
 		return
 	}
 	l := setupLog()
-	r := setupRouter(cfg, l)
-	err = r.Run(cfg.Addr)
+	r, wait, err := setupRouter(cfg, l)
+	if err != nil {
+		return
+	}
+	// background jobs (tile builds) are finished before exit
+	defer wait()
+	err = serve(cfg.Addr, r)
+}
+
+// serve runs http server until SIGINT or SIGTERM, then waits for active requests
+func serve(addr string, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: shutdownTimeout}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe() }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	log.Print("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 // exit after deferred cleanups have run
@@ -19,6 +19,7 @@ func TestRun(t *testing.T) {
 		{"Help", 3, []string{"-h"}},
 		{"UnknownFlag", 2, []string{"-0"}},
 		{"UnknownPort", 1, []string{"--http_addr", ":xx"}},
+		{"BadKey", 1, []string{"--auth.key", "bad"}},
 	}
 	for _, tt := range tests {
 		os.Args = append([]string{a[0]}, tt.args...)
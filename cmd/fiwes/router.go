@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"slices"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jessevdk/go-flags"
@@ -11,7 +12,11 @@ import (
 	"github.com/sirupsen/logrus"
 	"gopkg.in/birkirb/loggers.v1"
 
+	"github.com/LeKovr/fiwes/ginauth"
+	"github.com/LeKovr/fiwes/gincors"
+	"github.com/LeKovr/fiwes/ginlimit"
 	"github.com/LeKovr/fiwes/ginupload"
+	"github.com/LeKovr/fiwes/upload"
 )
 
 // Config holds all config vars
@@ -20,7 +25,12 @@ type Config struct {
 	UploadLimit int64  `long:"upload_limit" default:"8" description:"Upload size limit (Mb)"`
 	ShowHTML    bool   `long:"html" description:"Show html index page"`
 
-	Img ginupload.Config `group:"Image upload Options" namespace:"img"`
+	TrustedProxies []string `long:"trusted_proxies" description:"Proxy IP or CIDR trusted to set X-Forwarded-For and X-Forwarded-Proto, none if empty"`
+
+	Img   ginupload.Config `group:"Image upload Options" namespace:"img"`
+	Auth  ginauth.Config   `group:"Auth Options" namespace:"auth"`
+	Limit ginlimit.Config  `group:"Rate limit Options" namespace:"limit"`
+	CORS  gincors.Config   `group:"CORS Options" namespace:"cors"`
 }
 
 var (
@@ -59,23 +69,83 @@ func setupLog() loggers.Contextual {
 	return &mapper.Logger{Logger: l} // Same as mapper.NewLogger(l) but without info log message
 }
 
-// setupRouter creates gin router
-func setupRouter(cfg *Config, log loggers.Contextual) *gin.Engine {
+// setupRouter creates gin router, wait should be called after server shutdown to finish background jobs
+func setupRouter(cfg *Config, log loggers.Contextual) (*gin.Engine, func(), error) {
+	auth, err := ginauth.New(cfg.Auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit, err := ginlimit.New(cfg.Limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	cors, err := gincors.New(cfg.CORS)
+	if err != nil {
+		return nil, nil, err
+	}
 	router := gin.Default()
+	// client IP is used for rate limits and quotas, so it is taken from trusted proxies only
+	if err = router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, nil, err
+	}
+	// scheme of returned links is taken from trusted proxies only too
+	proxy, err := ginauth.TrustProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, nil, err
+	}
+	router.Use(proxy)
+	// CORS is used for all routes, including unknown ones, to answer preflight requests
+	router.Use(cors.Handler())
 	if cfg.ShowHTML {
 		router.Static("/static", "./assets/static")
 		router.StaticFile("/favicon.ico", "./assets/favicon.ico")
 		router.StaticFile("/", "./assets/index.html")
 	}
-	router.StaticFS(cfg.Img.Path, http.Dir(cfg.Img.Dir))
-	router.StaticFS(cfg.Img.PreviewPath, http.Dir(cfg.Img.PreviewDir))
+	gup, err := ginupload.New(cfg.Img, log, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	// image requests from other sites are denied, private images are served by signed links only
+	access := func(kind int, handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+		return append([]gin.HandlerFunc{gup.CheckHotlink(kind), gup.RequirePrivate(kind)}, handlers...)
+	}
+	// directory listings would disclose names of all stored images
+	// stored SVG is sanitized, sandbox protects from anything sanitizer missed
+	router.Group(cfg.Img.Path, access(ginupload.PrivateFile, ginupload.SandboxSVG())...).StaticFS("/", gin.Dir(cfg.Img.Dir, false))
+	router.Group(cfg.Img.PreviewPath, access(ginupload.PrivatePreview)...).StaticFS("/", gin.Dir(cfg.Img.PreviewDir, false))
+	router.Group(cfg.Img.TilesPath, access(ginupload.PrivateTile)...).StaticFS("/", gin.Dir(cfg.Img.TileDir, false))
 
 	// Set a lower memory limit for multipart forms (default is 32 MiB)
 	router.MaxMultipartMemory = cfg.UploadLimit << 20 // 8 MiB
 
-	gup := ginupload.New(cfg.Img, log, nil)
+	uploadAuth := auth.Require(ginauth.ScopeUpload)
+	setupUpload(router, cfg.Img.UploadPath, gup, auth.AllowPresigned(), uploadAuth, limit.Upload())
+
+	// avatar uploads differ by profile only
+	setupUpload(router, cfg.Img.AvatarPath, gup, ginupload.WithProfile(upload.ProfileAvatar), auth.AllowPresigned(), uploadAuth, limit.Upload())
+
+	// metadata is available to image owner only
+	readAuth := auth.Require("")
+	router.GET(cfg.Img.MetaPath, readAuth, gup.HandleList)
+	router.GET(cfg.Img.MetaPath+"/*name", readAuth, gup.HandleMeta)
+	router.POST(cfg.Img.SimilarPath, readAuth, gup.HandleSimilarFile)
+	router.GET(cfg.Img.SimilarPath+"/*name", readAuth, gup.HandleSimilar)
+	router.GET(cfg.Img.VariantPath+"/:size/*name", access(ginupload.PrivateName, gup.HandleVariant)...)
+	router.POST(cfg.Img.Path+"/*name", uploadAuth, gup.HandleEdit)
+	router.DELETE(cfg.Img.Path+"/*name", auth.Require(ginauth.ScopeDelete), gup.HandleDelete)
+	router.GET(cfg.Img.IIIFPath+"/*path", access(ginupload.PrivateIIIF, gup.HandleIIIF)...)
+	router.GET(cfg.Img.PagePath+"/:page/*name", access(ginupload.PrivateName, gup.HandlePage)...)
+	// contact sheet decodes up to MaxSheetTiles images
+	router.GET(cfg.Img.SheetPath, readAuth, limit.Sheet(), gup.HandleSheet)
+	router.POST(cfg.Img.SheetPath, readAuth, limit.Sheet(), gup.HandleSheet)
+	router.GET(cfg.Img.UsagePath, readAuth, gup.HandleUsage)
+	router.POST(cfg.Img.PresignPath, uploadAuth, auth.HandlePresign(cfg.Img.UploadPath))
+	return router, gup.Wait, nil
+}
 
-	router.POST(cfg.Img.UploadPath, func(c *gin.Context) {
+// setupUpload adds upload handlers of gup to router, middleware is called before them
+func setupUpload(router *gin.Engine, path string, gup *ginupload.Service, middleware ...gin.HandlerFunc) {
+	router.POST(path, append(slices.Clip(middleware), func(c *gin.Context) {
 		switch c.ContentType() {
 		case "multipart/form-data":
 			gup.HandleMultiPart(c)
@@ -84,9 +154,8 @@ func setupRouter(cfg *Config, log loggers.Contextual) *gin.Engine {
 		default:
 			c.String(http.StatusNotImplemented, "Content type (%s) not supported", c.ContentType())
 		}
-	})
-	router.GET(cfg.Img.UploadPath, func(c *gin.Context) {
+	})...)
+	router.GET(path, append(slices.Clip(middleware), func(c *gin.Context) {
 		gup.HandleURL(c)
-	})
-	return router
+	})...)
 }
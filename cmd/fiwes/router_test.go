@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	mapper "github.com/birkirb/loggers-mapper-logrus"
 	"github.com/gin-gonic/gin"
@@ -17,6 +25,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/LeKovr/fiwes/ginauth"
+	"github.com/LeKovr/fiwes/gincors"
+	"github.com/LeKovr/fiwes/ginlimit"
+	"github.com/LeKovr/fiwes/ginupload"
 )
 
 func TestSetupConfig(t *testing.T) {
@@ -56,7 +69,8 @@ func TestHandlers(t *testing.T) {
 	cfg.Img.Config.Dir, err = ioutil.TempDir("", "img")
 	require.NoError(t, err)
 	defer os.RemoveAll(cfg.Img.Config.Dir)
-	srv := setupRouter(cfg, log)
+	srv, _, err := setupRouter(cfg, log)
+	require.NoError(t, err)
 
 	tests := []struct {
 		name    string
@@ -75,6 +89,28 @@ func TestHandlers(t *testing.T) {
 			http.StatusBadRequest, "unsupported protocol scheme"},
 		{"BadCType", "POST", "/upload", nil, "application",
 			http.StatusNotImplemented, "Content type (application) not supported"},
+		{"AvatarBase64", "POST", "/avatar", strings.NewReader(`{"data":"data:image/png;base64,iVBORw0K","name":"file.png"}`), "application/json",
+			http.StatusUnsupportedMediaType, "unsupported media type"},
+		{"AvatarBadCType", "POST", "/avatar", nil, "application",
+			http.StatusNotImplemented, "Content type (application) not supported"},
+		{"MetaNotFound", "GET", "/meta/xx.png", nil, "",
+			http.StatusNotFound, "image not found"},
+		{"SimilarNotFound", "GET", "/similar/xx.png", nil, "",
+			http.StatusNotFound, "image not found"},
+		{"VariantNotFound", "GET", "/variant/10x10/xx.png", nil, "",
+			http.StatusNotFound, "image not found"},
+		{"EditNotFound", "POST", "/img/xx.png/edit", strings.NewReader(`[{"op":"grayscale"}]`), "application/json",
+			http.StatusNotFound, "image not found"},
+		{"IIIFNotFound", "GET", "/iiif/xx.png/info.json", nil, "",
+			http.StatusNotFound, "image not found"},
+		{"SheetNoImages", "GET", "/sheet?limit=5", nil, "",
+			http.StatusBadRequest, "contact sheet images count must be in range 1..100"},
+		{"PageNotFound", "GET", "/page/1/xx.tif", nil, "",
+			http.StatusNotFound, "image not found"},
+		{"DeleteNotFound", "DELETE", "/img/xx.png", nil, "",
+			http.StatusNotFound, "image not found"},
+		{"Usage", "GET", "/usage", nil, "",
+			http.StatusOK, "{}"},
 	}
 	for _, tt := range tests {
 		w := httptest.NewRecorder()
@@ -87,3 +123,215 @@ func TestHandlers(t *testing.T) {
 		assert.Equal(t, tt.message, w.Body.String(), tt.name)
 	}
 }
+
+func TestAuth(t *testing.T) {
+	cfg := &Config{}
+	p := flags.NewParser(cfg, flags.Default)
+	_, err := p.ParseArgs([]string{
+		"--auth.key", "ci:upload:" + ginauth.HashKey("secret"),
+		"--auth.key", "ops:admin:" + ginauth.HashKey("root"),
+		"--auth.presign_secret", "presign",
+	})
+	require.NoError(t, err)
+	l, _ := test.NewNullLogger()
+	cfg.Img.Config.Dir, err = os.MkdirTemp("", "img")
+	require.NoError(t, err)
+	defer os.RemoveAll(cfg.Img.Config.Dir)
+	srv, _, err := setupRouter(cfg, mapper.NewLogger(l))
+	require.NoError(t, err)
+	signed := ginauth.Presign{Owner: "alice", Expires: time.Now().Add(time.Hour)}.Query("presign", "/upload").Encode()
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		key     string
+		code    int
+		message string
+	}{
+		{"NoKey", "GET", "/upload?url=/img/xx.png", "", http.StatusUnauthorized, "authentication required"},
+		{"BadKey", "GET", "/upload?url=/img/xx.png", "bad", http.StatusUnauthorized, "authentication required"},
+		{"Upload", "GET", "/upload?url=/img/xx.png", "secret", http.StatusBadRequest, "unsupported protocol scheme"},
+		{"EditNoKey", "POST", "/img/xx.png/edit", "", http.StatusUnauthorized, "authentication required"},
+		{"DeleteNoScope", "DELETE", "/img/xx.png", "secret", http.StatusForbidden, "access denied"},
+		{"DeleteAdmin", "DELETE", "/img/xx.png", "root", http.StatusNotFound, "image not found"},
+		{"MetaNoKey", "GET", "/meta/xx.png", "", http.StatusUnauthorized, "authentication required"},
+		{"MetaOwned", "GET", "/meta/xx.png", "secret", http.StatusNotFound, "image not found"},
+		{"SheetNoKey", "GET", "/sheet?limit=1", "", http.StatusUnauthorized, "authentication required"},
+		{"ListNoKey", "GET", "/meta", "", http.StatusUnauthorized, "authentication required"},
+		{"ListOwned", "GET", "/meta", "secret", http.StatusOK, "[]"},
+		{"Presigned", "GET", "/upload?url=/img/xx.png&" + signed, "", http.StatusBadRequest, "unsupported protocol scheme"},
+		{"PresignedAvatar", "GET", "/avatar?url=/img/xx.png&" + signed, "", http.StatusUnauthorized, "invalid or expired signature"},
+		{"PresignedEdit", "POST", "/img/xx.png/edit?" + signed, "", http.StatusUnauthorized, "authentication required"},
+		{"PresignNoKey", "POST", "/presign", "", http.StatusUnauthorized, "authentication required"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		if tt.key != "" {
+			req.Header.Set(ginauth.HeaderAPIKey, tt.key)
+		}
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, tt.code, w.Code, tt.name)
+		assert.Equal(t, tt.message, w.Body.String(), tt.name)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := &Config{}
+	p := flags.NewParser(cfg, flags.Default)
+	_, err := p.ParseArgs([]string{"--limit.url_ip", "1/h"})
+	require.NoError(t, err)
+	l, _ := test.NewNullLogger()
+	cfg.Img.Config.Dir, err = os.MkdirTemp("", "img")
+	require.NoError(t, err)
+	defer os.RemoveAll(cfg.Img.Config.Dir)
+	srv, _, err := setupRouter(cfg, mapper.NewLogger(l))
+	require.NoError(t, err)
+
+	// X-Forwarded-For of untrusted client is ignored
+	for i, code := range []int{http.StatusBadRequest, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/upload?url=/img/xx.png", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code)
+	}
+
+	// X-Forwarded-For of trusted proxy is used
+	cfg.TrustedProxies = []string{"192.0.2.0/24"}
+	srv, _, err = setupRouter(cfg, mapper.NewLogger(l))
+	require.NoError(t, err)
+	for i := range 2 {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/upload?url=/img/xx.png", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	_, _, err = setupRouter(&Config{Limit: ginlimit.Config{URLIP: "bad"}}, mapper.NewLogger(l))
+	assert.Error(t, err)
+	_, _, err = setupRouter(&Config{TrustedProxies: []string{"bad"}}, mapper.NewLogger(l))
+	assert.Error(t, err)
+}
+
+func TestCORS(t *testing.T) {
+	cfg := &Config{}
+	p := flags.NewParser(cfg, flags.Default)
+	_, err := p.ParseArgs([]string{"--cors.origin", "https://app.example.com", "--cors.credentials"})
+	require.NoError(t, err)
+	l, _ := test.NewNullLogger()
+	cfg.Img.Config.Dir, err = os.MkdirTemp("", "img")
+	require.NoError(t, err)
+	defer os.RemoveAll(cfg.Img.Config.Dir)
+	srv, _, err := setupRouter(cfg, mapper.NewLogger(l))
+	require.NoError(t, err)
+
+	for _, route := range []struct{ method, url string }{{"POST", "/upload"}, {"DELETE", "/img/xx.png"}} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", route.url, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", route.method)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-api-key")
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code, route.url)
+		assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"), route.url)
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"), route.url)
+		assert.Equal(t, "GET, POST, DELETE", w.Header().Get("Access-Control-Allow-Methods"), route.url)
+		assert.Equal(t, "Content-Type, Authorization, X-API-Key", w.Header().Get("Access-Control-Allow-Headers"), route.url)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/upload", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://app.example.com")
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Location, Retry-After", w.Header().Get("Access-Control-Expose-Headers"))
+
+	_, _, err = setupRouter(&Config{CORS: gincors.Config{Origins: []string{"*"}, Credentials: true}}, mapper.NewLogger(l))
+	assert.Error(t, err)
+}
+
+// helperToken returns HS256 JWT with claims
+func helperToken(t *testing.T, secret string, claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	data, err := json.Marshal(claims)
+	require.NoError(t, err)
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestOwnerKinds(t *testing.T) {
+	cfg := &Config{}
+	p := flags.NewParser(cfg, flags.Default)
+	_, err := p.ParseArgs([]string{
+		"--auth.key", "alice:upload,delete:" + ginauth.HashKey("secret"),
+		"--auth.jwt_secret", "jwt",
+	})
+	require.NoError(t, err)
+	root := t.TempDir()
+	cfg.Img.Config.Dir = filepath.Join(root, "img")
+	cfg.Img.Config.PreviewDir = filepath.Join(root, "preview")
+	cfg.Img.Config.MetaDir = filepath.Join(root, "meta")
+	cfg.Img.Config.VariantDir = filepath.Join(root, "variant")
+	cfg.Img.Config.TileDir = filepath.Join(root, "tiles")
+	l, _ := test.NewNullLogger()
+	srv, _, err := setupRouter(cfg, mapper.NewLogger(l))
+	require.NoError(t, err)
+	token := helperToken(t, "jwt", map[string]any{"sub": "alice", "scope": "upload delete", "exp": time.Now().Add(time.Hour).Unix()})
+
+	data, err := os.ReadFile("../../testdata/build.json")
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/upload", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", ginauth.BearerPrefix+token)
+	srv.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var res ginupload.Result
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.True(t, strings.HasPrefix(res.File, "/img/user/alice/"), res.File)
+
+	// metadata is shown to owner only, dirs are not listed
+	name := strings.TrimPrefix(res.File, "/img")
+	for _, tt := range []struct {
+		path, header, value string
+		code                int
+	}{
+		{"/meta" + name, "", "", http.StatusUnauthorized},
+		{"/meta" + name, ginauth.HeaderAPIKey, "secret", http.StatusNotFound},
+		{"/meta" + name, "Authorization", ginauth.BearerPrefix + token, http.StatusOK},
+		{"/similar" + name, ginauth.HeaderAPIKey, "secret", http.StatusNotFound},
+		{"/img/user/alice/", "", "", http.StatusNotFound},
+	} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, tt.code, w.Code, tt.path)
+	}
+
+	// API key with ID equal to JWT user does not own user images
+	for _, auth := range []struct {
+		header, value string
+		code          int
+	}{
+		{ginauth.HeaderAPIKey, "secret", http.StatusNotFound},
+		{"Authorization", ginauth.BearerPrefix + token, http.StatusNoContent},
+	} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", res.File, nil)
+		req.Header.Set(auth.header, auth.value)
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, auth.code, w.Code, auth.header)
+	}
+}
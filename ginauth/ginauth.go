@@ -0,0 +1,197 @@
+// Package ginauth implements gin authentication middleware
+package ginauth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Config holds all config vars
+type Config struct {
+	Keys          []string      `long:"key" description:"API key as id:scope[,scope...]:sha256 hex of key"`
+	KeyFile       string        `long:"key_file" description:"File with API keys, one id:scope[,scope...]:sha256 per line"`
+	JWTSecret     string        `long:"jwt_secret" description:"Shared secret of HS256 JWT"`
+	JWKSFile      string        `long:"jwks_file" description:"JWKS file with RS256 (and HS256) JWT keys"`
+	JWTIssuer     string        `long:"jwt_issuer" description:"Required JWT issuer (iss)"`
+	JWTAudience   string        `long:"jwt_audience" description:"Required JWT audience (aud)"`
+	JWTUserClaim  string        `long:"jwt_user_claim" default:"sub" description:"JWT claim with user ID"`
+	JWTScopeClaim string        `long:"jwt_scope_claim" default:"scope" description:"JWT claim with scopes, upload is allowed if claim is absent"`
+	JWTAllowNoExp bool          `long:"jwt_allow_no_exp" description:"Accept JWT without exp claim (never expiring)"`
+	PresignSecret string        `long:"presign_secret" description:"Secret of presigned upload URLs"`
+	PresignMaxTTL time.Duration `long:"presign_max_ttl" default:"1h" description:"Max lifetime of presigned upload URL"`
+}
+
+const (
+	// ScopeUpload allows image upload and edit
+	ScopeUpload = "upload"
+	// ScopeDelete allows image delete
+	ScopeDelete = "delete"
+	// ScopeAdmin allows everything
+	ScopeAdmin = "admin"
+
+	// HeaderAPIKey holds request header with API key
+	HeaderAPIKey = "X-API-Key"
+	// ContextKeyID holds gin context key of authenticated API key ID
+	ContextKeyID = "ginauth.key_id"
+	// ContextUserID holds gin context key of user ID from authenticated JWT
+	ContextUserID = "ginauth.user_id"
+	// ContextAdmin holds gin context key of admin flag, it is set if authentication is not configured
+	ContextAdmin = "ginauth.admin"
+	// ContextOwner holds gin context key of owner ID set by presigned URL
+	ContextOwner = "ginauth.owner"
+	// OwnerKeyPrefix holds owner ID prefix of API key
+	OwnerKeyPrefix = "key:"
+	// OwnerUserPrefix holds owner ID prefix of JWT user
+	OwnerUserPrefix = "user:"
+	// BearerPrefix holds Authorization header prefix of JWT
+	BearerPrefix = "Bearer "
+
+	// ErrUnauthorized returned when request has no valid credentials
+	ErrUnauthorized = "authentication required"
+	// ErrForbidden returned when credentials do not allow request
+	ErrForbidden = "access denied"
+	// ErrFmtBadKey returned when API key config line is malformed
+	ErrFmtBadKey = "bad API key definition %q, want id:scope[,scope...]:sha256"
+)
+
+// Key holds API key definition
+type Key struct {
+	ID     string
+	Scopes []string
+}
+
+// Allows checks if key has scope
+func (k Key) Allows(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// Service holds authentication service
+type Service struct {
+	Config Config
+	keys   map[string]Key // by key hash
+	jwt    *jwtKeys
+	now    func() time.Time
+}
+
+// New creates a Service object
+func New(cfg Config) (*Service, error) {
+	jwt, err := loadJWTKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	srv := &Service{Config: cfg, keys: map[string]Key{}, jwt: jwt, now: time.Now}
+	defs := cfg.Keys
+	if cfg.KeyFile != "" {
+		file, err := os.Open(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				defs = append(defs, line)
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, def := range defs {
+		parts := strings.Split(def, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf(ErrFmtBadKey, def)
+		}
+		hash, err := hex.DecodeString(parts[2])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf(ErrFmtBadKey, def)
+		}
+		srv.keys[string(hash)] = Key{ID: parts[0], Scopes: strings.Split(parts[1], ",")}
+	}
+	return srv, nil
+}
+
+// Enabled checks if authentication is configured
+func (srv Service) Enabled() bool {
+	return len(srv.keys) > 0 || srv.jwt.enabled()
+}
+
+// HashKey returns hex encoded hash of API key as used in config
+func HashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Key returns definition of API key, ok is false for unknown key
+func (srv Service) Key(key string) (rv Key, ok bool) {
+	hash := sha256.Sum256([]byte(key))
+	rv, ok = srv.keys[string(hash[:])]
+	return
+}
+
+// Require returns middleware which allows only requests with API key or JWT having scope.
+// Empty scope allows any authenticated request.
+// All requests are allowed with admin rights if authentication is not configured.
+// Requests accepted by AllowPresigned are passed as is.
+func (srv Service) Require(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(ContextPresign); ok {
+			return
+		}
+		if !srv.Enabled() {
+			c.Set(ContextAdmin, true)
+			return
+		}
+		var user string
+		key, ok := srv.Key(c.GetHeader(HeaderAPIKey))
+		if token, found := strings.CutPrefix(c.GetHeader("Authorization"), BearerPrefix); !ok && found && srv.jwt.enabled() {
+			user, key, ok = srv.tokenKey(token)
+		}
+		if !ok {
+			if srv.jwt.enabled() {
+				c.Header("WWW-Authenticate", "Bearer")
+			}
+			abort(c, http.StatusUnauthorized, errors.New(ErrUnauthorized))
+			return
+		}
+		if scope != "" && !key.Allows(scope) {
+			abort(c, http.StatusForbidden, errors.New(ErrForbidden))
+			return
+		}
+		c.Set(ContextKeyID, key.ID)
+		c.Set(ContextUserID, user)
+		c.Set(ContextAdmin, key.Allows(ScopeAdmin))
+	}
+}
+
+// Owner returns owner ID of authenticated request: API key ID with OwnerKeyPrefix,
+// JWT user with OwnerUserPrefix or owner of presigned URL. Empty string means anonymous request.
+func Owner(c *gin.Context) string {
+	if owner := c.GetString(ContextOwner); owner != "" {
+		return owner
+	}
+	if id := c.GetString(ContextKeyID); id != "" {
+		return OwnerKeyPrefix + id
+	}
+	if user := c.GetString(ContextUserID); user != "" {
+		return OwnerUserPrefix + user
+	}
+	return ""
+}
+
+// abort stops request processing with error message
+func abort(c *gin.Context, status int, err error) {
+	c.String(status, err.Error())
+	c.Abort()
+}
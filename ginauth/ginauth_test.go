@@ -0,0 +1,87 @@
+package ginauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys")
+	data := "# CI uploads\nci:upload:" + HashKey("secret") + "\n\nops:delete,admin:" + HashKey("root") + "\n"
+	require.NoError(t, os.WriteFile(file, []byte(data), 0600))
+	srv, err := New(Config{KeyFile: file, Keys: []string{"web:upload,delete:" + HashKey("web")}})
+	require.NoError(t, err)
+	assert.True(t, srv.Enabled())
+
+	key, ok := srv.Key("secret")
+	require.True(t, ok)
+	assert.Equal(t, Key{ID: "ci", Scopes: []string{ScopeUpload}}, key)
+	assert.False(t, key.Allows(ScopeDelete))
+	key, ok = srv.Key("root")
+	require.True(t, ok)
+	assert.True(t, key.Allows(ScopeUpload))
+	key, ok = srv.Key("web")
+	require.True(t, ok)
+	assert.True(t, key.Allows(ScopeDelete))
+	assert.False(t, key.Allows(ScopeAdmin))
+	_, ok = srv.Key(HashKey("secret"))
+	assert.False(t, ok)
+
+	srv, err = New(Config{})
+	require.NoError(t, err)
+	assert.False(t, srv.Enabled())
+
+	for _, def := range []string{"ci:upload", ":upload:" + HashKey("x"), "ci:upload:xyz", "ci:upload:abcd"} {
+		_, err = New(Config{Keys: []string{def}})
+		assert.Error(t, err, def)
+	}
+	_, err = New(Config{KeyFile: filepath.Join(t.TempDir(), "none")})
+	assert.Error(t, err)
+}
+
+func TestRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv, err := New(Config{Keys: []string{"ci:upload:" + HashKey("secret"), "ops:admin:" + HashKey("root")}})
+	require.NoError(t, err)
+	open, err := New(Config{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		srv   *Service
+		scope string
+		key   string
+		code  int
+		id    string
+		admin bool
+	}{
+		{"OK", srv, ScopeUpload, "secret", http.StatusOK, "ci", false},
+		{"AnyScope", srv, "", "secret", http.StatusOK, "ci", false},
+		{"Admin", srv, ScopeDelete, "root", http.StatusOK, "ops", true},
+		{"NoKey", srv, ScopeUpload, "", http.StatusUnauthorized, "", false},
+		{"NoKeyAnyScope", srv, "", "", http.StatusUnauthorized, "", false},
+		{"Scope", srv, ScopeDelete, "secret", http.StatusForbidden, "", false},
+		{"Disabled", open, ScopeDelete, "", http.StatusOK, "", true},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Request, _ = http.NewRequest("POST", "/upload", nil)
+		if tt.key != "" {
+			c.Request.Header.Set(HeaderAPIKey, tt.key)
+		}
+		tt.srv.Require(tt.scope)(c)
+		c.Writer.WriteHeaderNow()
+		assert.Equal(t, tt.code, resp.Code, tt.name)
+		assert.Equal(t, tt.code != http.StatusOK, c.IsAborted(), tt.name)
+		assert.Equal(t, tt.id, c.GetString(ContextKeyID), tt.name)
+		assert.Equal(t, tt.admin, c.GetBool(ContextAdmin), tt.name)
+	}
+}
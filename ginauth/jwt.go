@@ -0,0 +1,200 @@
+package ginauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// ErrBadToken returned when JWT is malformed, has bad signature or claims
+	ErrBadToken = "invalid token"
+	// ErrBadJWKS returned when JWKS file has no usable keys
+	ErrBadJWKS = "no RSA or oct keys found in JWKS"
+
+	// ClockSkew holds allowed difference of token time claims and local clock
+	ClockSkew = 30 * time.Second
+
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+)
+
+// jwk holds JSON Web Key fields used for RS256 and HS256
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// jwtKeys holds JWT verification keys by kid, empty kid is used for token without kid
+type jwtKeys struct {
+	hmac map[string][]byte
+	rsa  map[string]*rsa.PublicKey
+}
+
+// loadJWTKeys loads shared secret and keys from JWKS file
+func loadJWTKeys(cfg Config) (*jwtKeys, error) {
+	rv := &jwtKeys{hmac: map[string][]byte{}, rsa: map[string]*rsa.PublicKey{}}
+	if cfg.JWTSecret != "" {
+		rv.hmac[""] = []byte(cfg.JWTSecret)
+	}
+	if cfg.JWKSFile == "" {
+		return rv, nil
+	}
+	data, err := os.ReadFile(cfg.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				continue
+			}
+			rv.rsa[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "oct":
+			if k, err := base64.RawURLEncoding.DecodeString(key.K); err == nil {
+				rv.hmac[key.Kid] = k
+			}
+		}
+	}
+	if len(rv.rsa)+len(rv.hmac) == 0 {
+		return nil, errors.New(ErrBadJWKS)
+	}
+	return rv, nil
+}
+
+// enabled checks if any JWT key is configured
+func (keys jwtKeys) enabled() bool {
+	return len(keys.rsa)+len(keys.hmac) > 0
+}
+
+// verify checks JWT signature and returns its claims
+func (keys jwtKeys) verify(token string) (map[string]any, error) {
+	bad := errors.New(ErrBadToken)
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, bad
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, bad
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, bad
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case algHS256:
+		secret, ok := keys.hmac[header.Kid]
+		if !ok {
+			return nil, bad
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, bad
+		}
+	case algRS256:
+		key, ok := keys.rsa[header.Kid]
+		if !ok || rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) != nil {
+			return nil, bad
+		}
+	default:
+		return nil, bad
+	}
+	claims := map[string]any{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, bad
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes base64url encoded JSON segment of JWT
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// checkClaims validates token time, issuer and audience claims.
+// Token without numeric exp is accepted only if JWTAllowNoExp is set.
+func (srv Service) checkClaims(claims map[string]any) bool {
+	now := srv.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok && (claims["exp"] != nil || !srv.Config.JWTAllowNoExp) {
+		return false
+	}
+	if ok && now.After(time.Unix(int64(exp), 0).Add(ClockSkew)) {
+		return false
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-ClockSkew)) {
+		return false
+	}
+	cfg := srv.Config
+	if cfg.JWTIssuer != "" && claims["iss"] != cfg.JWTIssuer {
+		return false
+	}
+	if cfg.JWTAudience != "" && !slices.Contains(claimList(claims["aud"]), cfg.JWTAudience) {
+		return false
+	}
+	return true
+}
+
+// claimList returns values of claim which may be string (space separated) or array of strings
+func claimList(claim any) (rv []string) {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				rv = append(rv, s)
+			}
+		}
+	}
+	return
+}
+
+// tokenKey returns key definition of request authorized by JWT.
+// Key ID is empty, scopes are taken from JWTScopeClaim or set to upload if claim is absent.
+func (srv Service) tokenKey(token string) (user string, key Key, ok bool) {
+	claims, err := srv.jwt.verify(token)
+	if err != nil || !srv.checkClaims(claims) {
+		return
+	}
+	user, _ = claims[srv.Config.JWTUserClaim].(string)
+	if user == "" {
+		return
+	}
+	key.Scopes = []string{ScopeUpload}
+	if scopes, found := claims[srv.Config.JWTScopeClaim]; found {
+		key.Scopes = claimList(scopes)
+	}
+	return user, key, true
+}
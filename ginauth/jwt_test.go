@@ -0,0 +1,168 @@
+package ginauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// helperToken returns JWT signed by key ([]byte for HS256 or *rsa.PrivateKey for RS256)
+func helperToken(t *testing.T, header, claims map[string]any, key any) string {
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := segment(header) + "." + segment(claims)
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		hash := sha256.Sum256([]byte(input))
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+		require.NoError(t, err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "gw", "n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec"},
+	}})
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(file, jwks, 0600))
+
+	srv, err := New(Config{JWTSecret: "secret", JWKSFile: file, JWTIssuer: "gw", JWTAudience: "fiwes",
+		JWTUserClaim: "sub", JWTScopeClaim: "scope"})
+	require.NoError(t, err)
+	assert.True(t, srv.Enabled())
+	now := time.Now()
+	srv.now = func() time.Time { return now }
+
+	hs := map[string]any{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]any{"alg": "RS256", "kid": "gw"}
+	claims := func(extra map[string]any) map[string]any {
+		rv := map[string]any{"sub": "alice", "iss": "gw", "aud": []string{"other", "fiwes"}, "exp": now.Add(time.Minute).Unix()}
+		for k, v := range extra {
+			rv[k] = v
+		}
+		return rv
+	}
+	noExp := claims(nil)
+	delete(noExp, "exp")
+	tests := []struct {
+		name   string
+		token  string
+		ok     bool
+		scopes []string
+	}{
+		{"HS256", helperToken(t, hs, claims(nil), []byte("secret")), true, []string{ScopeUpload}},
+		{"RS256", helperToken(t, rs, claims(map[string]any{"scope": "upload delete"}), rsaKey), true, []string{ScopeUpload, ScopeDelete}},
+		{"ScopeList", helperToken(t, hs, claims(map[string]any{"scope": []string{"admin"}, "aud": "fiwes"}), []byte("secret")), true, []string{ScopeAdmin}},
+		{"BadSecret", helperToken(t, hs, claims(nil), []byte("other")), false, nil},
+		{"UnknownKid", helperToken(t, map[string]any{"alg": "RS256", "kid": "ec"}, claims(nil), rsaKey), false, nil},
+		{"AlgNone", helperToken(t, map[string]any{"alg": "none"}, claims(nil), nil), false, nil},
+		{"Expired", helperToken(t, hs, claims(map[string]any{"exp": now.Add(-time.Minute).Unix()}), []byte("secret")), false, nil},
+		{"NoExp", helperToken(t, hs, noExp, []byte("secret")), false, nil},
+		{"StringExp", helperToken(t, hs, claims(map[string]any{"exp": "never"}), []byte("secret")), false, nil},
+		{"NotBefore", helperToken(t, hs, claims(map[string]any{"nbf": now.Add(time.Minute).Unix()}), []byte("secret")), false, nil},
+		{"Issuer", helperToken(t, hs, claims(map[string]any{"iss": "evil"}), []byte("secret")), false, nil},
+		{"Audience", helperToken(t, hs, claims(map[string]any{"aud": "other"}), []byte("secret")), false, nil},
+		{"NoUser", helperToken(t, hs, claims(map[string]any{"sub": ""}), []byte("secret")), false, nil},
+		{"Malformed", "a.b", false, nil},
+	}
+	for _, tt := range tests {
+		user, key, ok := srv.tokenKey(tt.token)
+		assert.Equal(t, tt.ok, ok, tt.name)
+		if tt.ok {
+			assert.Equal(t, "alice", user, tt.name)
+			assert.Equal(t, tt.scopes, key.Scopes, tt.name)
+		}
+	}
+
+	// token without exp is accepted if allowed explicitly
+	srv.Config.JWTAllowNoExp = true
+	_, _, ok := srv.tokenKey(helperToken(t, hs, noExp, []byte("secret")))
+	assert.True(t, ok)
+	_, _, ok = srv.tokenKey(tests[8].token)
+	assert.False(t, ok, "non-numeric exp is rejected")
+	srv.Config.JWTAllowNoExp = false
+
+	// middleware
+	gin.SetMode(gin.TestMode)
+	for _, tt := range []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"OK", tests[0].token, http.StatusOK},
+		{"Scope", tests[0].token, http.StatusForbidden},
+		{"Bad", tests[3].token, http.StatusUnauthorized},
+	} {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Request, _ = http.NewRequest("POST", "/upload", nil)
+		c.Request.Header.Set("Authorization", BearerPrefix+tt.token)
+		scope := ScopeUpload
+		if tt.name == "Scope" {
+			scope = ScopeDelete
+		}
+		srv.Require(scope)(c)
+		c.Writer.WriteHeaderNow()
+		assert.Equal(t, tt.code, resp.Code, tt.name)
+		if tt.code == http.StatusOK {
+			assert.Equal(t, "alice", c.GetString(ContextUserID), tt.name)
+		}
+		if tt.code == http.StatusUnauthorized {
+			assert.Equal(t, "Bearer", resp.Header().Get("WWW-Authenticate"), tt.name)
+		}
+	}
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"empty.json": `{"keys":[{"kty":"EC"}]}`,
+		"bad.json":   `{"keys":`,
+	} {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte(data), 0600))
+		_, err := New(Config{JWKSFile: file})
+		assert.Error(t, err, name)
+	}
+	_, err := New(Config{JWKSFile: filepath.Join(dir, "none.json")})
+	assert.Error(t, err)
+
+	file := filepath.Join(dir, "oct.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"keys":[{"kty":"oct","kid":"k1","k":"c2VjcmV0"}]}`), 0600))
+	srv, err := New(Config{JWKSFile: file, JWTUserClaim: "uid"})
+	require.NoError(t, err)
+	token := helperToken(t, map[string]any{"alg": "HS256", "kid": "k1"}, map[string]any{"uid": "bob", "exp": time.Now().Add(time.Minute).Unix()}, []byte("secret"))
+	user, _, ok := srv.tokenKey(token)
+	assert.True(t, ok)
+	assert.Equal(t, "bob", user)
+}
@@ -0,0 +1,180 @@
+package ginauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ContextPresign holds gin context key of verified *Presign
+	ContextPresign = "ginauth.presign"
+
+	// Presigned URL query params
+	ParamOwner     = "owner"
+	ParamExpires   = "expires"
+	ParamMaxSize   = "max_size"
+	ParamFormats   = "formats"
+	ParamName      = "name"
+	ParamSignature = "signature"
+
+	// ErrBadSignature returned when presigned URL signature is invalid or expired
+	ErrBadSignature = "invalid or expired signature"
+	// ErrPresignDisabled returned when presign secret is not configured
+	ErrPresignDisabled = "presigned URLs are not configured"
+	// ErrPresignTTL returned when requested presigned URL TTL is out of range
+	ErrPresignTTL = "presigned URL ttl is out of range"
+)
+
+// Presign holds constraints of presigned upload URL
+type Presign struct {
+	Owner   string    `json:"owner,omitempty"`
+	Expires time.Time `json:"expires"`
+	MaxSize int64     `json:"max_size,omitempty"` // bytes, 0 - unlimited
+	Formats []string  `json:"formats,omitempty"`  // allowed file extensions, empty - any
+	Name    string    `json:"name,omitempty"`     // target file name, empty - from request
+}
+
+// Query returns query params of upload path signed with secret
+func (p Presign) Query(secret, path string) url.Values {
+	q := p.values()
+	q.Set(ParamSignature, sign(secret, path, q))
+	return q
+}
+
+// values returns unsigned query params
+func (p Presign) values() url.Values {
+	q := url.Values{}
+	q.Set(ParamExpires, strconv.FormatInt(p.Expires.Unix(), 10))
+	if p.Owner != "" {
+		q.Set(ParamOwner, p.Owner)
+	}
+	if p.MaxSize > 0 {
+		q.Set(ParamMaxSize, strconv.FormatInt(p.MaxSize, 10))
+	}
+	if len(p.Formats) > 0 {
+		q.Set(ParamFormats, strings.Join(p.Formats, ","))
+	}
+	if p.Name != "" {
+		q.Set(ParamName, p.Name)
+	}
+	return q
+}
+
+// PresignURL returns presigned upload URL, base is absolute URL of upload path
+func PresignURL(secret, base string, p Presign) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = p.Query(secret, u.Path).Encode()
+	return u.String(), nil
+}
+
+// sign returns hex encoded HMAC-SHA256 of path and params
+func sign(secret, path string, q url.Values) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(path + "?" + q.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parsePresign checks signature and expiry of presigned URL params
+func parsePresign(secret, path string, q url.Values, now time.Time) (*Presign, error) {
+	bad := errors.New(ErrBadSignature)
+	p := &Presign{Owner: q.Get(ParamOwner), Name: q.Get(ParamName)}
+	expires, err := strconv.ParseInt(q.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return nil, bad
+	}
+	p.Expires = time.Unix(expires, 0)
+	if q.Has(ParamMaxSize) {
+		if p.MaxSize, err = strconv.ParseInt(q.Get(ParamMaxSize), 10, 64); err != nil {
+			return nil, bad
+		}
+	}
+	if formats := q.Get(ParamFormats); formats != "" {
+		p.Formats = strings.Split(formats, ",")
+	}
+	want := sign(secret, path, p.values())
+	if !hmac.Equal([]byte(want), []byte(q.Get(ParamSignature))) || now.After(p.Expires) {
+		return nil, bad
+	}
+	return p, nil
+}
+
+// AllowPresigned returns middleware which authenticates upload requests with presigned URL.
+// Verified constraints are stored in context as ContextPresign and Require skips such requests.
+func (srv Service) AllowPresigned() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if srv.Config.PresignSecret == "" || !c.Request.URL.Query().Has(ParamSignature) {
+			return
+		}
+		p, err := parsePresign(srv.Config.PresignSecret, c.Request.URL.Path, c.Request.URL.Query(), srv.now())
+		if err != nil {
+			abort(c, http.StatusUnauthorized, err)
+			return
+		}
+		c.Set(ContextPresign, p)
+		c.Set(ContextOwner, p.Owner)
+		c.Set(ContextAdmin, false)
+	}
+}
+
+// PresignRequest holds presigned URL request
+type PresignRequest struct {
+	TTL     int      `json:"ttl"` // seconds, 0 - max allowed
+	MaxSize int64    `json:"max_size"`
+	Formats []string `json:"formats"`
+	Name    string   `json:"name"`
+}
+
+// PresignResult holds presigned URL response
+type PresignResult struct {
+	URL string `json:"url"`
+	Presign
+}
+
+// HandlePresign returns handler which issues presigned URLs of uploadPath for authenticated caller.
+// Issued URL uploads to caller namespace (see Owner).
+func (srv Service) HandlePresign(uploadPath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if srv.Config.PresignSecret == "" {
+			abort(c, http.StatusNotFound, errors.New(ErrPresignDisabled))
+			return
+		}
+		var req PresignRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ttl := time.Duration(req.TTL) * time.Second
+		if ttl == 0 {
+			ttl = srv.Config.PresignMaxTTL
+		}
+		if ttl < 0 || ttl > srv.Config.PresignMaxTTL {
+			abort(c, http.StatusBadRequest, errors.New(ErrPresignTTL))
+			return
+		}
+		p := Presign{
+			Owner:   Owner(c),
+			Expires: srv.now().Add(ttl).Truncate(time.Second),
+			MaxSize: req.MaxSize,
+			Formats: req.Formats,
+			Name:    req.Name,
+		}
+		link, err := PresignURL(srv.Config.PresignSecret, Scheme(c)+"://"+c.Request.Host+uploadPath, p)
+		if err != nil {
+			abort(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, PresignResult{URL: link, Presign: p})
+	}
+}
@@ -0,0 +1,94 @@
+package ginauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresign(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := Presign{Owner: "alice", Expires: now.Add(time.Minute), MaxSize: 1024, Formats: []string{"png", "jpg"}, Name: "cat.png"}
+	link, err := PresignURL("secret", "http://localhost/upload", p)
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", link, nil)
+
+	got, err := parsePresign("secret", req.URL.Path, req.URL.Query(), now)
+	require.NoError(t, err)
+	assert.Equal(t, p, *got)
+
+	tests := []struct {
+		name   string
+		secret string
+		path   string
+		query  string
+		now    time.Time
+	}{
+		{"Expired", "secret", "/upload", req.URL.RawQuery, now.Add(time.Hour)},
+		{"OtherSecret", "other", "/upload", req.URL.RawQuery, now},
+		{"OtherPath", "secret", "/avatar", req.URL.RawQuery, now},
+		{"Changed", "secret", "/upload", strings.Replace(req.URL.RawQuery, "max_size=1024", "max_size=2048", 1), now},
+		{"NoExpires", "secret", "/upload", "signature=x", now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.path+"?"+tt.query, nil)
+			_, err := parsePresign(tt.secret, tt.path, r.URL.Query(), tt.now)
+			assert.EqualError(t, err, ErrBadSignature)
+		})
+	}
+}
+
+func TestAllowPresigned(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv, err := New(Config{Keys: []string{"ci:upload:" + HashKey("secret")}, PresignSecret: "presign", PresignMaxTTL: time.Hour})
+	require.NoError(t, err)
+	handler := func(c *gin.Context) { c.String(http.StatusOK, Owner(c)) }
+	router := gin.New()
+	router.POST("/upload", srv.AllowPresigned(), srv.Require(ScopeUpload), handler)
+	router.POST("/presign", srv.Require(ScopeUpload), srv.HandlePresign("/upload"))
+
+	// unsigned request needs key
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("POST", "/upload", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/presign", strings.NewReader(`{"ttl":60,"max_size":100,"formats":["png"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderAPIKey, "secret")
+	// scheme of untrusted client is ignored
+	req.Header.Set("X-Forwarded-Proto", "https")
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var res PresignResult
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	assert.Equal(t, "key:ci", res.Owner)
+	assert.Equal(t, int64(100), res.MaxSize)
+	assert.True(t, strings.HasPrefix(res.URL, "http://example.com/upload?"), res.URL)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("POST", res.URL, nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "key:ci", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("POST", res.URL+"0", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, ErrBadSignature, resp.Body.String())
+
+	// ttl is limited
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/presign", strings.NewReader(`{"ttl":7200}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderAPIKey, "secret")
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -0,0 +1,54 @@
+package ginauth
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContextProxy holds gin context key of flag set for requests from trusted proxy
+const ContextProxy = "ginauth.proxy"
+
+// TrustProxies returns middleware which marks requests from proxies given (IP or CIDR),
+// so Scheme uses their X-Forwarded-Proto
+func TrustProxies(proxies []string) (gin.HandlerFunc, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return func(c *gin.Context) {
+		ip := net.ParseIP(c.RemoteIP())
+		for _, n := range nets {
+			if n.Contains(ip) {
+				c.Set(ContextProxy, true)
+				return
+			}
+		}
+	}, nil
+}
+
+// Scheme returns request scheme. X-Forwarded-Proto is used for requests from trusted proxy only,
+// so client can not choose scheme of links returned by service.
+func Scheme(c *gin.Context) string {
+	if c.GetBool(ContextProxy) {
+		if proto := strings.ToLower(c.GetHeader("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
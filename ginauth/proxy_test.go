@@ -0,0 +1,49 @@
+package ginauth
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheme(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	proxy, err := TrustProxies([]string{"192.0.2.0/24", "2001:db8::1"})
+	require.NoError(t, err)
+	tests := []struct {
+		name   string
+		remote string
+		proto  string
+		tls    bool
+		scheme string
+	}{
+		{"Plain", "198.51.100.1:1234", "", false, "http"},
+		{"TLS", "198.51.100.1:1234", "", true, "https"},
+		{"Untrusted", "198.51.100.1:1234", "https", false, "http"},
+		{"UntrustedTLS", "198.51.100.1:1234", "http", true, "https"},
+		{"Trusted", "192.0.2.1:1234", "https", false, "https"},
+		{"TrustedIPv6", "[2001:db8::1]:1234", "HTTPS", false, "https"},
+		{"TrustedBad", "192.0.2.1:1234", "javascript", false, "http"},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/", nil)
+		c.Request.RemoteAddr = tt.remote
+		if tt.proto != "" {
+			c.Request.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if tt.tls {
+			c.Request.TLS = &tls.ConnectionState{}
+		}
+		proxy(c)
+		assert.Equal(t, tt.scheme, Scheme(c), tt.name)
+	}
+
+	_, err = TrustProxies([]string{"bad"})
+	assert.Error(t, err)
+}
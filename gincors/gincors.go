@@ -0,0 +1,112 @@
+// Package gincors implements gin CORS middleware
+package gincors
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Config holds all config vars
+type Config struct {
+	Origins       []string `long:"origin" description:"Allowed origin (scheme://host[:port], *.example.com for subdomains, * for any), CORS is disabled if empty"`
+	Methods       []string `long:"method" default:"GET" default:"POST" default:"DELETE" description:"Allowed methods"`
+	Headers       []string `long:"header" default:"Content-Type" default:"Authorization" default:"X-API-Key" description:"Allowed request headers"`
+	ExposeHeaders []string `long:"expose_header" default:"Location" default:"Retry-After" description:"Response headers available to scripts"`
+	Credentials   bool     `long:"credentials" description:"Allow requests with cookies and HTTP authentication"`
+	MaxAge        int      `long:"max_age" default:"600" description:"Preflight response cache lifetime (seconds)"`
+}
+
+// ErrWildcardCredentials returned when credentials are allowed for any origin
+const ErrWildcardCredentials = "credentials can not be allowed for any origin"
+
+// Service holds CORS service
+type Service struct {
+	Config Config
+}
+
+// New creates a Service object
+func New(cfg Config) (*Service, error) {
+	if cfg.Credentials && slices.Contains(cfg.Origins, "*") {
+		return nil, errors.New(ErrWildcardCredentials)
+	}
+	return &Service{Config: cfg}, nil
+}
+
+// allowed checks if origin matches config
+func (srv Service) allowed(origin string) bool {
+	_, host, ok := strings.Cut(origin, "://")
+	if !ok || host == "" {
+		return false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, item := range srv.Config.Origins {
+		if item == "*" || strings.EqualFold(item, origin) {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(item, "*"); ok && strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler returns middleware which sets CORS headers and answers preflight requests.
+// It should be used for all routes (via Use before routes setup) for preflight requests to be handled.
+func (srv Service) Handler() gin.HandlerFunc {
+	cfg := srv.Config
+	methods := strings.Join(cfg.Methods, ", ")
+	headers := strings.Join(cfg.Headers, ", ")
+	expose := strings.Join(cfg.ExposeHeaders, ", ")
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if len(cfg.Origins) == 0 || origin == "" {
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !srv.allowed(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+			}
+			// browser blocks response without CORS headers
+			return
+		}
+		if slices.Contains(cfg.Origins, "*") {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.Credentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			if expose != "" {
+				c.Header("Access-Control-Expose-Headers", expose)
+			}
+			return
+		}
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !slices.Contains(cfg.Methods, c.GetHeader("Access-Control-Request-Method")) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Header("Access-Control-Allow-Methods", methods)
+		if headers != "" {
+			c.Header("Access-Control-Allow-Headers", headers)
+		}
+		if cfg.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
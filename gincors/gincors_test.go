@@ -0,0 +1,68 @@
+package gincors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowed(t *testing.T) {
+	srv, err := New(Config{Origins: []string{"https://app.example.com", "*.example.org"}})
+	require.NoError(t, err)
+	assert.True(t, srv.allowed("https://app.example.com"))
+	assert.True(t, srv.allowed("HTTPS://APP.example.com"))
+	assert.False(t, srv.allowed("http://app.example.com"))
+	assert.True(t, srv.allowed("https://www.example.org:8443"))
+	assert.False(t, srv.allowed("https://example.org"))
+	assert.False(t, srv.allowed("https://badexample.org"))
+	assert.False(t, srv.allowed("null"))
+
+	_, err = New(Config{Origins: []string{"*"}, Credentials: true})
+	assert.EqualError(t, err, ErrWildcardCredentials)
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		cfg     Config
+		method  string
+		origin  string
+		request string
+		code    int
+		allow   string
+	}{
+		{"Disabled", Config{}, "OPTIONS", "https://a.com", "POST", http.StatusNotFound, ""},
+		{"Preflight", Config{Origins: []string{"https://a.com"}, Methods: []string{"POST"}}, "OPTIONS", "https://a.com", "POST", http.StatusNoContent, "https://a.com"},
+		{"PreflightAny", Config{Origins: []string{"*"}, Methods: []string{"POST"}}, "OPTIONS", "https://a.com", "POST", http.StatusNoContent, "*"},
+		{"PreflightMethod", Config{Origins: []string{"https://a.com"}, Methods: []string{"POST"}}, "OPTIONS", "https://a.com", "DELETE", http.StatusForbidden, "https://a.com"},
+		{"PreflightOrigin", Config{Origins: []string{"https://a.com"}, Methods: []string{"POST"}}, "OPTIONS", "https://b.com", "POST", http.StatusForbidden, ""},
+		{"Simple", Config{Origins: []string{"https://a.com"}}, "POST", "https://a.com", "", http.StatusOK, "https://a.com"},
+		{"SimpleOrigin", Config{Origins: []string{"https://a.com"}}, "POST", "https://b.com", "", http.StatusOK, ""},
+		{"NoOrigin", Config{Origins: []string{"https://a.com"}}, "POST", "", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := New(tt.cfg)
+			require.NoError(t, err)
+			router := gin.New()
+			router.Use(srv.Handler())
+			router.POST("/upload", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+			req := httptest.NewRequest(tt.method, "/upload", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.request != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.request)
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			assert.Equal(t, tt.code, resp.Code)
+			assert.Equal(t, tt.allow, resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Package ginlimit implements gin rate limiting middleware
+package ginlimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/LeKovr/fiwes/ginauth"
+)
+
+// Config holds all config vars.
+// Limits are set as count/period[:burst] where period is s, m or h (e.g. 10/m:20), empty means unlimited.
+type Config struct {
+	MultipartIP  string `long:"multipart_ip" description:"Multipart uploads limit per client IP"`
+	MultipartKey string `long:"multipart_key" description:"Multipart uploads limit per API key or user"`
+	Base64IP     string `long:"base64_ip" description:"Base64 uploads limit per client IP"`
+	Base64Key    string `long:"base64_key" description:"Base64 uploads limit per API key or user"`
+	URLIP        string `long:"url_ip" description:"URL uploads limit per client IP"`
+	URLKey       string `long:"url_key" description:"URL uploads limit per API key or user"`
+	URLHost      string `long:"url_host" description:"URL uploads limit per remote host"`
+	SheetIP      string `long:"sheet_ip" default:"10/m" description:"Contact sheet requests limit per client IP"`
+	SheetKey     string `long:"sheet_key" description:"Contact sheet requests limit per API key or user"`
+}
+
+const (
+	// KindMultipart holds upload kind of multipart form
+	KindMultipart = "multipart"
+	// KindBase64 holds upload kind of JSON with base64 data
+	KindBase64 = "base64"
+	// KindURL holds upload kind of image URL
+	KindURL = "url"
+	// KindSheet holds request kind of contact sheet
+	KindSheet = "sheet"
+
+	// ErrTooManyRequests returned when request exceeds rate limit
+	ErrTooManyRequests = "rate limit exceeded"
+	// ErrFmtBadLimit returned when limit config is malformed
+	ErrFmtBadLimit = "bad rate limit %q, want count/period[:burst]"
+
+	// maxBuckets holds buckets count of limiter which triggers removal of full buckets
+	maxBuckets = 10000
+	// sweepInterval holds period of idle (full) buckets removal
+	sweepInterval = time.Minute
+)
+
+// periods holds limit periods by suffix
+var periods = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// bucket holds token bucket state
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter holds token buckets by key
+type limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	mu    sync.Mutex
+	items map[string]*bucket
+	swept time.Time // last sweep time
+}
+
+// newLimiter parses limit definition, returns nil for empty definition
+func newLimiter(def string) (*limiter, error) {
+	if def == "" {
+		return nil, nil
+	}
+	bad := fmt.Errorf(ErrFmtBadLimit, def)
+	spec, burstDef, hasBurst := strings.Cut(def, ":")
+	countDef, periodDef, ok := strings.Cut(spec, "/")
+	period, found := periods[periodDef]
+	count, err := strconv.Atoi(countDef)
+	if !ok || !found || err != nil || count < 1 {
+		return nil, bad
+	}
+	burst := count
+	if hasBurst {
+		if burst, err = strconv.Atoi(burstDef); err != nil || burst < 1 {
+			return nil, bad
+		}
+	}
+	return &limiter{rate: float64(count) / period.Seconds(), burst: float64(burst), items: map[string]*bucket{}}, nil
+}
+
+// take removes token from bucket of key. If bucket is empty, it returns time to wait for token.
+// Token is not removed if dryRun is true.
+func (l *limiter) take(key string, now time.Time, dryRun bool) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.items) >= maxBuckets || now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.items[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.items[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	if !dryRun {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// sweep removes buckets which are full already
+func (l *limiter) sweep(now time.Time) {
+	l.swept = now
+	for key, b := range l.items {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.items, key)
+		}
+	}
+}
+
+// limit holds limiter and bucket key of request
+type limit struct {
+	l   *limiter
+	key string
+}
+
+// Service holds rate limiting service
+type Service struct {
+	Config Config
+	ip     map[string]*limiter // by upload kind
+	key    map[string]*limiter // by upload kind
+	host   *limiter
+	now    func() time.Time
+}
+
+// New creates a Service object
+func New(cfg Config) (*Service, error) {
+	srv := &Service{Config: cfg, ip: map[string]*limiter{}, key: map[string]*limiter{}, now: time.Now}
+	defs := []struct {
+		def  string
+		dest map[string]*limiter
+		kind string
+	}{
+		{cfg.MultipartIP, srv.ip, KindMultipart},
+		{cfg.MultipartKey, srv.key, KindMultipart},
+		{cfg.Base64IP, srv.ip, KindBase64},
+		{cfg.Base64Key, srv.key, KindBase64},
+		{cfg.URLIP, srv.ip, KindURL},
+		{cfg.URLKey, srv.key, KindURL},
+		{cfg.SheetIP, srv.ip, KindSheet},
+		{cfg.SheetKey, srv.key, KindSheet},
+	}
+	for _, d := range defs {
+		l, err := newLimiter(d.def)
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			d.dest[d.kind] = l
+		}
+	}
+	var err error
+	if srv.host, err = newLimiter(cfg.URLHost); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// uploadKind returns upload kind of request
+func uploadKind(c *gin.Context) string {
+	if c.Request.Method == http.MethodGet {
+		return KindURL
+	}
+	if c.ContentType() == "application/json" {
+		return KindBase64
+	}
+	return KindMultipart
+}
+
+// Upload returns middleware which limits upload requests by kind.
+// It must be called after auth middleware to limit by API key.
+func (srv Service) Upload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kind := uploadKind(c)
+		now := srv.now()
+		checks := []limit{
+			{srv.ip[kind], c.ClientIP()},
+			{srv.key[kind], requester(c)},
+		}
+		if kind == KindURL {
+			if u, err := url.Parse(c.Query("url")); err == nil {
+				checks = append(checks, limit{srv.host, strings.ToLower(u.Hostname())})
+			}
+		}
+		allow(c, now, checks)
+	}
+}
+
+// Sheet returns middleware which limits contact sheet requests.
+// It must be called after auth middleware to limit by API key.
+func (srv Service) Sheet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allow(c, srv.now(), []limit{
+			{srv.ip[KindSheet], c.ClientIP()},
+			{srv.key[KindSheet], requester(c)},
+		})
+	}
+}
+
+// allow aborts request with 429 if any of limits is exceeded.
+// Tokens are taken only if all limits allow request.
+func allow(c *gin.Context, now time.Time, checks []limit) {
+	for _, dryRun := range []bool{true, false} {
+		for _, check := range checks {
+			if check.l == nil || check.key == "" {
+				continue
+			}
+			if ok, wait := check.l.take(check.key, now, dryRun); !ok {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				c.String(http.StatusTooManyRequests, ErrTooManyRequests)
+				c.Abort()
+				return
+			}
+		}
+	}
+}
+
+// requester returns owner ID (API key, JWT user or presigned URL owner) of request
+func requester(c *gin.Context) string {
+	return ginauth.Owner(c)
+}
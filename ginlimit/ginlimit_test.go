@@ -0,0 +1,137 @@
+package ginlimit
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/LeKovr/fiwes/ginauth"
+)
+
+func TestNewLimiter(t *testing.T) {
+	l, err := newLimiter("")
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	l, err = newLimiter("10/m:20")
+	require.NoError(t, err)
+	assert.InDelta(t, 10.0/60, l.rate, 0.0001)
+	assert.Equal(t, 20.0, l.burst)
+
+	for _, def := range []string{"10", "10/d", "x/s", "0/s", "1/s:0", "1/s:x"} {
+		_, err = newLimiter(def)
+		assert.EqualError(t, err, fmt.Sprintf(ErrFmtBadLimit, def), def)
+	}
+	_, err = New(Config{URLHost: "bad"})
+	assert.Error(t, err)
+	_, err = New(Config{Base64Key: "bad"})
+	assert.Error(t, err)
+}
+
+func TestTake(t *testing.T) {
+	l, err := newLimiter("2/s")
+	require.NoError(t, err)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		ok, _ := l.take("a", now, false)
+		assert.True(t, ok)
+	}
+	ok, wait := l.take("a", now, true)
+	assert.False(t, ok)
+	ok, wait = l.take("a", now, false)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	ok, _ = l.take("b", now, true)
+	assert.True(t, ok, "dry run")
+	ok, _ = l.take("b", now, false)
+	assert.True(t, ok, "other key")
+	ok, _ = l.take("a", now.Add(wait), false)
+	assert.True(t, ok, "refilled")
+
+	// full buckets are removed periodically
+	l.take("c", now.Add(sweepInterval), false)
+	assert.Equal(t, []string{"c"}, slices.Collect(maps.Keys(l.items)))
+}
+
+func TestUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv, err := New(Config{MultipartIP: "1/m", Base64Key: "1/h", URLHost: "1/s", URLIP: "2/s"})
+	require.NoError(t, err)
+	now := time.Now()
+	srv.now = func() time.Time { return now }
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		ctype  string
+		key    string
+		code   int
+		retry  string
+	}{
+		{"Multipart", "POST", "/upload", "multipart/form-data", "", http.StatusOK, ""},
+		{"MultipartLimit", "POST", "/upload", "multipart/form-data", "", http.StatusTooManyRequests, "60"},
+		{"Base64NoKey", "POST", "/upload", "application/json", "", http.StatusOK, ""},
+		{"Base64Key", "POST", "/upload", "application/json", "ci", http.StatusOK, ""},
+		{"Base64KeyLimit", "POST", "/upload", "application/json", "ci", http.StatusTooManyRequests, "3600"},
+		{"Base64OtherKey", "POST", "/upload", "application/json", "web", http.StatusOK, ""},
+		{"URL", "GET", "/upload?url=https://example.com/a.png", "", "", http.StatusOK, ""},
+		{"URLHostLimit", "GET", "/upload?url=https://EXAMPLE.com/b.png", "", "", http.StatusTooManyRequests, "1"},
+		{"URLOtherHost", "GET", "/upload?url=https://example.org/a.png", "", "", http.StatusOK, ""},
+		{"URLIPLimit", "GET", "/upload?url=https://example.net/a.png", "", "", http.StatusTooManyRequests, "1"},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Request, _ = http.NewRequest(tt.method, tt.url, strings.NewReader(""))
+		c.Request.RemoteAddr = "192.0.2.1:1234"
+		if tt.ctype != "" {
+			c.Request.Header.Set("Content-Type", tt.ctype)
+		}
+		if tt.key != "" {
+			c.Set(ginauth.ContextKeyID, tt.key)
+		}
+		srv.Upload()(c)
+		c.Writer.WriteHeaderNow()
+		assert.Equal(t, tt.code, resp.Code, tt.name)
+		assert.Equal(t, tt.retry, resp.Header().Get("Retry-After"), tt.name)
+	}
+}
+
+func TestSheet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv, err := New(Config{SheetIP: "2/m", SheetKey: "1/m"})
+	require.NoError(t, err)
+	now := time.Now()
+	srv.now = func() time.Time { return now }
+
+	for i, tt := range []struct {
+		key  string
+		code int
+	}{
+		{"ci", http.StatusOK},
+		{"ci", http.StatusTooManyRequests},
+		{"", http.StatusOK},
+		{"", http.StatusTooManyRequests},
+	} {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Request, _ = http.NewRequest("GET", "/sheet?limit=10", nil)
+		c.Request.RemoteAddr = "192.0.2.1:1234"
+		if tt.key != "" {
+			c.Set(ginauth.ContextKeyID, tt.key)
+		}
+		srv.Sheet()(c)
+		c.Writer.WriteHeaderNow()
+		assert.Equal(t, tt.code, resp.Code, i)
+	}
+}
@@ -4,12 +4,23 @@ package ginupload
 //go:generate moq -out upload_moq_test.go . Uploader
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
 	"gopkg.in/birkirb/loggers.v1"
 
+	"github.com/LeKovr/fiwes/ginauth"
 	"github.com/LeKovr/fiwes/upload"
 )
 
@@ -18,14 +29,59 @@ type Config struct {
 	upload.Config
 	Path        string `long:"path" default:"/img" description:"Image URL path"`
 	UploadPath  string `long:"upload_path" default:"/upload" description:"Image upload URL path"`
+	AvatarPath  string `long:"avatar_path" default:"/avatar" description:"Avatar upload URL path (uses avatar profile)"`
 	PreviewPath string `long:"preview_path" default:"/preview" description:"Preview image URL path"`
+	MetaPath    string `long:"meta_path" default:"/meta" description:"Image metadata URL path"`
+	SimilarPath string `long:"similar_path" default:"/similar" description:"Similar images search URL path"`
+	VariantPath string `long:"variant_path" default:"/variant" description:"On-demand image variant URL path"`
+	IIIFPath    string `long:"iiif_path" default:"/iiif" description:"IIIF Image API URL path"`
+	TilesPath   string `long:"tiles_path" default:"/tiles" description:"Deep zoom tiles URL path"`
+	PagePath    string `long:"page_path" default:"/page" description:"Multi-page image page URL path"`
+	SheetPath   string `long:"sheet_path" default:"/sheet" description:"Contact sheet URL path"`
+	UsagePath   string `long:"usage_path" default:"/usage" description:"Storage usage URL path"`
+	PresignPath string `long:"presign_path" default:"/presign" description:"Presigned upload URL request path"`
+
+	PrivateSecret string        `long:"private_secret" description:"Secret of private image signed links, private uploads are disabled if empty"`
+	PrivateTTL    time.Duration `long:"private_ttl" default:"1h" description:"Lifetime of signed links returned for private uploads"`
+
+	HotlinkAllow       []string `long:"hotlink_allow" description:"Hosts allowed to embed images (*.example.com for subdomains), all if empty"`
+	HotlinkBlockEmpty  bool     `long:"hotlink_block_empty" description:"Deny image requests without Origin and Referer if hotlink_allow is set"`
+	HotlinkPlaceholder string   `long:"hotlink_placeholder" description:"Image file sent instead of denied hotlinked image (403 if empty)"`
 }
 
+const (
+	// EditSuffix holds image URL suffix of edit request
+	EditSuffix = "/edit"
+	// IIIFInfoSuffix holds IIIF image information request suffix
+	IIIFInfoSuffix = "/info.json"
+	// IIIFProfileLink holds IIIF compliance level link header
+	IIIFProfileLink = `<http://iiif.io/api/image/3/level2.json>;rel="profile"`
+	// iiifImageParts holds count of IIIF image request path segments after identifier
+	iiifImageParts = 4
+	// SheetOutputJSON holds contact sheet output param value for JSON response
+	SheetOutputJSON = "json"
+	// ContextProfile holds gin context key of upload processing profile
+	ContextProfile = "ginupload.profile"
+)
+
 // Uploader holds methods of underlying upload package
 type Uploader interface {
-	HandleMultiPart(form *multipart.Form) (*string, error)
-	HandleURL(url string) (*string, error)
-	HandleBase64(data, name string) (*string, error)
+	HandleMultiPart(form *multipart.Form, attrs upload.Attrs) (*string, error)
+	HandleURL(url string, attrs upload.Attrs) (*string, error)
+	HandleBase64(data, name string, attrs upload.Attrs) (*string, error)
+	Delete(name string, attrs upload.Attrs) error
+	Meta(name string) (*upload.Meta, error)
+	OwnedMeta(name string, attrs upload.Attrs) (*upload.Meta, error)
+	List(attrs upload.Attrs) ([]upload.Meta, error)
+	Similar(name string, distance int, attrs upload.Attrs) ([]upload.Match, error)
+	SimilarFile(form *multipart.Form, distance int, attrs upload.Attrs) ([]upload.Match, error)
+	Variant(name string, width, height int) (string, error)
+	Edit(name string, ops []upload.Operation, attrs upload.Attrs) (*string, error)
+	IIIFInfo(name string) (*upload.IIIFInfo, error)
+	IIIFImage(name string, params upload.IIIFParams) ([]byte, error)
+	Page(name string, page int) ([]byte, error)
+	Sheet(req upload.SheetRequest, attrs upload.Attrs) (*upload.Sheet, error)
+	Usage(attrs upload.Attrs) (*upload.Usage, error)
 }
 
 // Service holds ginupload service
@@ -34,12 +90,39 @@ type Service struct {
 	up     Uploader
 }
 
-// New creates a Service object
-func New(cfg Config, log loggers.Contextual, upl Uploader) *Service {
+// New creates a Service object, upload.Service is used if upl is nil
+func New(cfg Config, log loggers.Contextual, upl Uploader) (*Service, error) {
 	if upl == nil {
-		upl = upload.New(cfg.Config, log)
+		up, err := upload.New(cfg.Config, log)
+		if err != nil {
+			return nil, err
+		}
+		upl = up
+	}
+	return &Service{cfg, upl}, nil
+}
+
+// Wait waits for background jobs of uploader (if any) to finish
+func (srv Service) Wait() {
+	if w, ok := srv.up.(interface{ Wait() }); ok {
+		w.Wait()
+	}
+}
+
+// WithProfile returns middleware which sets upload processing profile of request
+func WithProfile(profile string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ContextProfile, profile)
+	}
+}
+
+// SandboxSVG returns middleware which disables scripts of SVG files opened in browser
+func SandboxSVG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.EqualFold(path.Ext(c.Request.URL.Path), upload.SVGExt) {
+			c.Header("Content-Security-Policy", "sandbox")
+		}
 	}
-	return &Service{cfg, upl}
 }
 
 // HandleMultiPart handles a file received as multipart form
@@ -50,29 +133,41 @@ func (srv Service) HandleMultiPart(c *gin.Context) {
 		logError(c, err)
 		return
 	}
-	name, err := srv.up.HandleMultiPart(form)
+	attrs, err := srv.privateAttrs(c, privateFlag(c.PostForm("private")))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	name, err := srv.up.HandleMultiPart(form, attrs)
 	if err != nil {
 		logError(c, err)
 		return
 	}
-	c.Redirect(http.StatusFound, srv.Config.PreviewPath+*name)
+	c.Redirect(http.StatusFound, srv.privateLink(srv.Config.PreviewPath+upload.RasterName(*name), *name, attrs.Private))
 }
 
 // HandleURL handles an image from url field
 func (srv Service) HandleURL(c *gin.Context) {
 	url := c.Query("url")
-	name, err := srv.up.HandleURL(url)
+	attrs, err := srv.privateAttrs(c, privateFlag(c.Query("private")))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	name, err := srv.up.HandleURL(url, attrs)
 	if err != nil {
 		logError(c, err)
 		return
 	}
-	c.Redirect(http.StatusFound, srv.Config.PreviewPath+*name)
+	c.Redirect(http.StatusFound, srv.privateLink(srv.Config.PreviewPath+upload.RasterName(*name), *name, attrs.Private))
 }
 
 // File hold JSON request struct
 type File struct {
 	Name string `form:"name" json:"name" binding:"required"`
 	Data string `form:"data" json:"data" binding:"required"`
+	// Private image is served by signed links only
+	Private bool `form:"private" json:"private"`
 }
 
 // HandleBase64 reads POST with JSON data (data:image/png;base64,...) and returns JSON with links to file and preview
@@ -82,13 +177,132 @@ func (srv Service) HandleBase64(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	name, err := srv.up.HandleBase64(json.Data, json.Name)
+	attrs, err := srv.privateAttrs(c, json.Private)
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	name, err := srv.up.HandleBase64(json.Data, json.Name, attrs)
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	srv.sendResult(c, *name)
+}
+
+// HandleEdit reads POST {Path}/{name}/edit with JSON list of edit operations
+// and returns JSON with links to new image version
+func (srv Service) HandleEdit(c *gin.Context) {
+	name, ok := strings.CutSuffix(c.Param("name"), EditSuffix)
+	if !ok {
+		c.String(http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+	var ops []upload.Operation
+	if err := c.ShouldBindJSON(&ops); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newName, err := srv.up.Edit(name, ops, requestAttrs(c))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	srv.sendResult(c, *newName)
+}
+
+// HandleDelete removes image by DELETE {Path}/{name}
+func (srv Service) HandleDelete(c *gin.Context) {
+	if err := srv.up.Delete(c.Param("name"), requestAttrs(c)); err != nil {
+		logError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// requestAttrs returns upload request attributes set by auth middleware.
+// Images are owned by JWT user or, for API key requests, by key, see ginauth.Owner.
+func requestAttrs(c *gin.Context) upload.Attrs {
+	rv := upload.Attrs{
+		KeyID:   c.GetString(ginauth.ContextKeyID),
+		Owner:   ginauth.Owner(c),
+		Admin:   c.GetBool(ginauth.ContextAdmin),
+		IP:      c.ClientIP(),
+		Profile: c.GetString(ContextProfile),
+	}
+	if p, ok := c.Get(ginauth.ContextPresign); ok {
+		p := p.(*ginauth.Presign)
+		rv.Constraints = &upload.Constraints{MaxSize: p.MaxSize, Formats: p.Formats, Name: p.Name}
+	}
+	return rv
+}
+
+// sendResult sends JSON with links to file and preview and image metadata
+func (srv Service) sendResult(c *gin.Context, name string) {
+	meta, err := srv.up.Meta(name)
 	if err != nil {
 		logError(c, err)
 		return
 	}
 	cfg := srv.Config
-	c.JSON(http.StatusOK, gin.H{"file": cfg.Path + *name, "preview": cfg.PreviewPath + *name})
+	rv := Result{
+		File:    srv.privateLink(cfg.Path+name, name, meta.Private),
+		Preview: srv.privateLink(cfg.PreviewPath+upload.RasterName(name), name, meta.Private),
+		Meta:    meta,
+	}
+	srcset := make([]string, 0, len(meta.SrcsetWidths))
+	for _, w := range meta.SrcsetWidths {
+		src := Source{URL: srv.privateLink(fmt.Sprintf("%s/%dx0%s", cfg.VariantPath, w, name), name, meta.Private), Width: w}
+		rv.Sources = append(rv.Sources, src)
+		srcset = append(srcset, fmt.Sprintf("%s %dw", src.URL, src.Width))
+	}
+	rv.Srcset = strings.Join(srcset, ", ")
+	c.JSON(http.StatusOK, rv)
+}
+
+// Result holds upload JSON response
+type Result struct {
+	File    string   `json:"file"`
+	Preview string   `json:"preview"`
+	Srcset  string   `json:"srcset,omitempty"`
+	Sources []Source `json:"sources,omitempty"`
+	*upload.Meta
+}
+
+// Source holds responsive image copy link
+type Source struct {
+	URL   string `json:"url"`
+	Width int    `json:"width"`
+}
+
+// HandleMeta returns JSON with metadata of caller's image
+func (srv Service) HandleMeta(c *gin.Context) {
+	meta, err := srv.up.OwnedMeta(c.Param("name"), requestAttrs(c))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, meta)
+}
+
+// HandleUsage returns JSON with storage usage and quotas of caller
+func (srv Service) HandleUsage(c *gin.Context) {
+	usage, err := srv.up.Usage(requestAttrs(c))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// HandleList returns JSON with metadata of all stored images
+func (srv Service) HandleList(c *gin.Context) {
+	list, err := srv.up.List(requestAttrs(c))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
 }
 
 // logError fills response with error message
@@ -102,3 +316,182 @@ func logError(c *gin.Context, err error) {
 	}
 	c.String(status, err.Error())
 }
+
+// HandleSimilar returns JSON with caller's stored images similar to stored image
+func (srv Service) HandleSimilar(c *gin.Context) {
+	distance, err := srv.distance(c)
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	list, err := srv.up.Similar(c.Param("name"), distance, requestAttrs(c))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// HandleSimilarFile returns JSON with caller's stored images similar to image received as multipart form
+func (srv Service) HandleSimilarFile(c *gin.Context) {
+	distance, err := srv.distance(c)
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		err = upload.NewHTTPError(http.StatusBadRequest, err)
+		logError(c, err)
+		return
+	}
+	list, err := srv.up.SimilarFile(form, distance, requestAttrs(c))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// HandleVariant sends image copy resized to size from path ({width}x{height})
+func (srv Service) HandleVariant(c *gin.Context) {
+	width, height, err := parseSize(c.Param("size"))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	file, err := srv.up.Variant(c.Param("name"), width, height)
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	c.File(file)
+}
+
+// HandleIIIF handles IIIF Image API requests:
+// {IIIFPath}/{identifier}/info.json and {IIIFPath}/{identifier}/{region}/{size}/{rotation}/{quality}.{format}
+func (srv Service) HandleIIIF(c *gin.Context) {
+	// identifier may contain slashes (encoded or not), so parse path from the end
+	reqPath := strings.TrimPrefix(c.Param("path"), "/")
+	if name, ok := strings.CutSuffix(reqPath, IIIFInfoSuffix); ok {
+		info, err := srv.up.IIIFInfo(name)
+		if err != nil {
+			logError(c, err)
+			return
+		}
+		info.ID = requestBase(c) + srv.Config.IIIFPath + "/" + url.PathEscape(name)
+		if strings.Contains(c.GetHeader("Accept"), "application/ld+json") {
+			c.Header("Content-Type", `application/ld+json;profile="`+upload.IIIFContext+`"`)
+			c.Render(http.StatusOK, render.JSON{Data: info})
+			return
+		}
+		c.JSON(http.StatusOK, info)
+		return
+	}
+	parts := strings.Split(reqPath, "/")
+	if len(parts) <= iiifImageParts {
+		// base URI, redirect to image information
+		c.Redirect(http.StatusSeeOther, srv.Config.IIIFPath+"/"+url.PathEscape(reqPath)+IIIFInfoSuffix)
+		return
+	}
+	args := parts[len(parts)-iiifImageParts:]
+	quality, format, ok := strings.Cut(args[3], ".")
+	if !ok {
+		c.String(http.StatusBadRequest, upload.ErrIIIFFormat)
+		return
+	}
+	name := strings.Join(parts[:len(parts)-iiifImageParts], "/")
+	data, err := srv.up.IIIFImage(name, upload.IIIFParams{
+		Region:   args[0],
+		Size:     args[1],
+		Rotation: args[2],
+		Quality:  quality,
+		Format:   format,
+	})
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	c.Header("Link", IIIFProfileLink)
+	c.Data(http.StatusOK, mime.TypeByExtension("."+format), data)
+}
+
+// HandlePage sends page of multi-page image from path ({page}/{name}) as PNG
+func (srv Service) HandlePage(c *gin.Context) {
+	page, err := strconv.Atoi(c.Param("page"))
+	if err != nil {
+		logError(c, upload.NewHTTPError(http.StatusBadRequest, err))
+		return
+	}
+	data, err := srv.up.Page(c.Param("name"), page)
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "image/png", data)
+}
+
+// SheetResult holds contact sheet JSON response
+type SheetResult struct {
+	Image string `json:"image"` // data URI
+	*upload.Sheet
+}
+
+// HandleSheet sends contact sheet of images from query (GET) or JSON (POST).
+// With output=json query param, JSON with sheet data URI and tile offsets is sent.
+func (srv Service) HandleSheet(c *gin.Context) {
+	var req upload.SheetRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sheet, err := srv.up.Sheet(req, requestAttrs(c))
+	if err != nil {
+		logError(c, err)
+		return
+	}
+	ctype := mime.TypeByExtension("." + sheet.Format)
+	if c.Query("output") != SheetOutputJSON {
+		c.Data(http.StatusOK, ctype, sheet.Image)
+		return
+	}
+	c.JSON(http.StatusOK, SheetResult{
+		Image: "data:" + ctype + ";base64," + base64.StdEncoding.EncodeToString(sheet.Image),
+		Sheet: sheet,
+	})
+}
+
+// requestBase returns scheme and host of request, scheme of proxy is used if proxy is trusted
+func requestBase(c *gin.Context) string {
+	return ginauth.Scheme(c) + "://" + c.Request.Host
+}
+
+// parseSize parses size string like "100x50", zero means "keep aspect ratio"
+func parseSize(size string) (width, height int, err error) {
+	w, h, ok := strings.Cut(size, "x")
+	if !ok {
+		err = upload.NewHTTPError(http.StatusBadRequest, errors.New(upload.ErrBadSize))
+		return
+	}
+	if width, err = strconv.Atoi(w); err != nil {
+		err = upload.NewHTTPError(http.StatusBadRequest, err)
+		return
+	}
+	if height, err = strconv.Atoi(h); err != nil {
+		err = upload.NewHTTPError(http.StatusBadRequest, err)
+	}
+	return
+}
+
+// distance returns Hamming distance from query or config default
+func (srv Service) distance(c *gin.Context) (int, error) {
+	arg := c.Query("distance")
+	if arg == "" {
+		return srv.Config.SimilarDistance, nil
+	}
+	distance, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, upload.NewHTTPError(http.StatusBadRequest, err)
+	}
+	return distance, nil
+}
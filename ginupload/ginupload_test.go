@@ -21,6 +21,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/LeKovr/fiwes/ginauth"
 	"github.com/LeKovr/fiwes/upload"
 )
 
@@ -45,8 +46,8 @@ func (ss *ServerSuite) SetupSuite() {
 
 	hook.Reset()
 
-	ss.srv = New(ss.cfg, log, &UploaderMock{
-		HandleMultiPartFunc: func(form *multipart.Form) (*string, error) {
+	ss.srv, err = New(ss.cfg, log, &UploaderMock{
+		HandleMultiPartFunc: func(form *multipart.Form, attrs upload.Attrs) (*string, error) {
 			_, ok := form.File["file"]
 			if !ok {
 				return nil, upload.NewHTTPError(
@@ -57,29 +58,107 @@ func (ss *ServerSuite) SetupSuite() {
 			n := "/index"
 			return &n, nil
 		},
-		HandleBase64Func: func(data, name string) (*string, error) {
+		HandleBase64Func: func(data, name string, attrs upload.Attrs) (*string, error) {
 			if name != "file.png" {
 				return nil, upload.NewHTTPError(http.StatusUnsupportedMediaType, errors.New(upload.ErrNoCTypeExt))
 			}
 			n := "/" + name
 			return &n, nil
 		},
-		HandleURLFunc: func(url string) (*string, error) {
+		HandleURLFunc: func(url string, attrs upload.Attrs) (*string, error) {
 			if strings.HasSuffix(url, "error.png") {
 				return nil, errors.New("unhandled error")
 			}
 			n := "/" + path.Base(url)
 			return &n, nil
 		},
+		MetaFunc: func(name string) (*upload.Meta, error) {
+			if name != "/file.png" {
+				return nil, upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrNotFound))
+			}
+			return &upload.Meta{Name: name, Width: 1, Height: 1, BlurHash: "00TI:j", SrcsetWidths: []int{1}}, nil
+		},
+		OwnedMetaFunc: func(name string, attrs upload.Attrs) (*upload.Meta, error) {
+			if name != "/file.png" {
+				return nil, upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrNotFound))
+			}
+			return &upload.Meta{Name: name, Width: 1, Height: 1, BlurHash: "00TI:j", SrcsetWidths: []int{1}}, nil
+		},
+		DeleteFunc: func(name string, attrs upload.Attrs) error {
+			if name != "/file.png" {
+				return upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrNotFound))
+			}
+			return nil
+		},
+		ListFunc: func(attrs upload.Attrs) ([]upload.Meta, error) {
+			return []upload.Meta{{Name: "/file.png", Width: 1, Height: 1}}, nil
+		},
+		SimilarFunc: func(name string, distance int, attrs upload.Attrs) ([]upload.Match, error) {
+			if name != "/file.png" {
+				return nil, upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrNotFound))
+			}
+			return []upload.Match{}, nil
+		},
+		SimilarFileFunc: func(form *multipart.Form, distance int, attrs upload.Attrs) ([]upload.Match, error) {
+			return []upload.Match{{Meta: upload.Meta{Name: "/file.png"}, Distance: distance}}, nil
+		},
+		EditFunc: func(name string, ops []upload.Operation, attrs upload.Attrs) (*string, error) {
+			if name != "/file.png" {
+				return nil, upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrNotFound))
+			}
+			return &name, nil
+		},
+		VariantFunc: func(name string, width, height int) (string, error) {
+			if name != "/file.png" {
+				return "", upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrNotFound))
+			}
+			return "../testdata/build100.png", nil
+		},
+		IIIFInfoFunc: func(name string) (*upload.IIIFInfo, error) {
+			if name != "file.png" {
+				return nil, upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrNotFound))
+			}
+			return &upload.IIIFInfo{Width: 1, Height: 1}, nil
+		},
+		IIIFImageFunc: func(name string, params upload.IIIFParams) ([]byte, error) {
+			if params.Format != "png" {
+				return nil, upload.NewHTTPError(http.StatusBadRequest, errors.New(upload.ErrIIIFFormat))
+			}
+			return []byte(name), nil
+		},
+		PageFunc: func(name string, page int) ([]byte, error) {
+			if name != "/file.png" || page != 1 {
+				return nil, upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrPageNotFound))
+			}
+			return []byte(name), nil
+		},
+		UsageFunc: func(attrs upload.Attrs) (*upload.Usage, error) {
+			return &upload.Usage{Owner: attrs.Owner, IP: attrs.IP, ByIP: &upload.UsageStat{Files: 1, Bytes: 10}}, nil
+		},
+		SheetFunc: func(req upload.SheetRequest, attrs upload.Attrs) (*upload.Sheet, error) {
+			if len(req.Names) == 0 {
+				return nil, upload.NewHTTPError(http.StatusBadRequest, errors.New(upload.ErrSheetSize))
+			}
+			return &upload.Sheet{Image: []byte("png"), Format: "png", Width: 1, Height: 1,
+				Tiles: map[string]upload.SheetTile{req.Names[0]: {Width: 1, Height: 1}}}, nil
+		},
 	})
+	require.NoError(ss.T(), err)
 }
 
 func (ss *ServerSuite) TestNew() {
 	l, _ := test.NewNullLogger()
 	log := mapper.NewLogger(l)
-	srv := New(ss.cfg, log, nil)
+	srv, err := New(ss.cfg, log, nil)
+	require.NoError(ss.T(), err)
 	require.NotNil(ss.T(), srv)
 	require.NotNil(ss.T(), srv.up)
+
+	// bad watermark fails at startup
+	cfg := ss.cfg
+	cfg.Watermark = "../testdata/unknown.png"
+	_, err = New(cfg, log, nil)
+	assert.NotNil(ss.T(), err)
 }
 
 func (ss *ServerSuite) TestHandleMultiPart() {
@@ -124,7 +203,9 @@ func (ss *ServerSuite) TestHandleBase64() {
 		message string
 	}{
 		{"OK", strings.NewReader(`{"data":"data:image/png;base64,iVBORw0K","name":"file.png"}`),
-			http.StatusOK, `{"file":"/img/file.png","preview":"/preview/file.png"}`},
+			http.StatusOK, `{"file":"/img/file.png","preview":"/preview/file.png","srcset":"/variant/1x0/file.png 1w",` +
+				`"sources":[{"url":"/variant/1x0/file.png","width":1}],"name":"/file.png","size":0,"width":1,"height":1,` +
+				`"created":"0001-01-01T00:00:00Z","blurhash":"00TI:j","srcset_widths":[1]}`},
 		{"NoImage", strings.NewReader(`{"data":"data:image/png;base64,iVBORw0K","name":"file.ext"}`), http.StatusUnsupportedMediaType, ""},
 		{"NoJSON", strings.NewReader(``), http.StatusBadRequest, ""},
 	}
@@ -140,6 +221,63 @@ func (ss *ServerSuite) TestHandleBase64() {
 	}
 }
 
+func (ss *ServerSuite) TestRequestAttrs() {
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	c.Request, _ = http.NewRequest("POST", "/upload", strings.NewReader(`{"data":"data:image/png;base64,iVBORw0K","name":"file.png"}`))
+	c.Set(ginauth.ContextUserID, "alice")
+	ss.srv.HandleBase64(c)
+	require.Equal(ss.T(), http.StatusOK, resp.Code)
+	calls := ss.srv.up.(*UploaderMock).HandleBase64Calls()
+	assert.Equal(ss.T(), upload.Attrs{Owner: "user:alice"}, calls[len(calls)-1].Attrs)
+
+	// API key request is owned by key, key and user with the same ID are different owners
+	resp = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(resp)
+	c.Request, _ = http.NewRequest("GET", "/usage", nil)
+	c.Request.RemoteAddr = "192.0.2.1:1234"
+	c.Set(ginauth.ContextKeyID, "ci")
+	c.Set(ginauth.ContextAdmin, true)
+	assert.Equal(ss.T(), upload.Attrs{KeyID: "ci", Owner: "key:ci", Admin: true, IP: "192.0.2.1"}, requestAttrs(c))
+
+	ss.srv.HandleUsage(c)
+	assert.Equal(ss.T(), http.StatusOK, resp.Code)
+	assert.Equal(ss.T(), `{"owner":"key:ci","ip":"192.0.2.1","by_ip":{"files":1,"bytes":10}}`, resp.Body.String())
+
+	// presigned request has constraints
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/upload", nil)
+	c.Set(ginauth.ContextUserID, "alice")
+	c.Set(ginauth.ContextPresign, &ginauth.Presign{Owner: "alice", MaxSize: 10, Formats: []string{"png"}, Name: "file.png"})
+	assert.Equal(ss.T(), &upload.Constraints{MaxSize: 10, Formats: []string{"png"}, Name: "file.png"}, requestAttrs(c).Constraints)
+
+	// profile is set by middleware
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/avatar", nil)
+	WithProfile(upload.ProfileAvatar)(c)
+	assert.Equal(ss.T(), upload.ProfileAvatar, requestAttrs(c).Profile)
+}
+
+func (ss *ServerSuite) TestHandleDelete() {
+	tests := []struct {
+		name string
+		file string
+		code int
+	}{
+		{"OK", "/file.png", http.StatusNoContent},
+		{"NotFound", "/xx.png", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Request, _ = http.NewRequest("DELETE", "/img"+tt.file, nil)
+		c.Params = gin.Params{{Key: "name", Value: tt.file}}
+		ss.srv.HandleDelete(c)
+		c.Writer.WriteHeaderNow()
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+	}
+}
+
 func (ss *ServerSuite) TestHandleURL() {
 	tests := []struct {
 		name    string
@@ -169,7 +307,260 @@ func (ss *ServerSuite) TestHandleURL() {
 	}
 }
 
+func (ss *ServerSuite) TestHandleMeta() {
+	tests := []struct {
+		name string
+		file string
+		code int
+	}{
+		{"OK", "/file.png", http.StatusOK},
+		{"NotFound", "/unknown.png", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Params = gin.Params{{Key: "name", Value: tt.file}}
+		c.Request, _ = http.NewRequest(http.MethodGet, "/meta"+tt.file, nil)
+		ss.srv.HandleMeta(c)
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+	}
+}
+
+func (ss *ServerSuite) TestHandleList() {
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/meta", nil)
+	ss.srv.HandleList(c)
+	assert.Equal(ss.T(), http.StatusOK, resp.Code)
+	assert.Equal(ss.T(), `[{"name":"/file.png","size":0,"width":1,"height":1,"created":"0001-01-01T00:00:00Z"}]`, resp.Body.String())
+}
+
+func (ss *ServerSuite) TestHandleSimilar() {
+	tests := []struct {
+		name  string
+		file  string
+		query string
+		code  int
+	}{
+		{"OK", "/file.png", "", http.StatusOK},
+		{"Distance", "/file.png", "?distance=5", http.StatusOK},
+		{"BadDistance", "/file.png", "?distance=x", http.StatusBadRequest},
+		{"NotFound", "/unknown.png", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Params = gin.Params{{Key: "name", Value: tt.file}}
+		c.Request, _ = http.NewRequest(http.MethodGet, "/similar"+tt.file+tt.query, nil)
+		ss.srv.HandleSimilar(c)
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+	}
+}
+
+func (ss *ServerSuite) TestHandleSimilarFile() {
+	tests := []struct {
+		name    string
+		reader  io.Reader
+		query   string
+		code    int
+		message string
+	}{
+		{"OK", nil, "?distance=3", http.StatusOK,
+			`[{"name":"/file.png","size":0,"width":0,"height":0,"created":"0001-01-01T00:00:00Z","distance":3}]`},
+		{"BadDistance", nil, "?distance=x", http.StatusBadRequest, ""},
+		{"NoForm", strings.NewReader(`fake data`), "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		ctype := "multipart/form-data"
+		if tt.reader == nil {
+			body := new(bytes.Buffer)
+			writer := multipart.NewWriter(body)
+			_, err := writer.CreateFormFile("file", "file.png")
+			require.NoError(ss.T(), err)
+			require.NoError(ss.T(), writer.Close())
+			tt.reader = body
+			ctype = writer.FormDataContentType()
+		}
+		c.Request, _ = http.NewRequest("POST", "/similar"+tt.query, tt.reader)
+		c.Request.Header.Set("Content-Type", ctype)
+		ss.srv.HandleSimilarFile(c)
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+		if tt.message != "" {
+			assert.Equal(ss.T(), tt.message, resp.Body.String(), tt.name)
+		}
+	}
+}
+
+func (ss *ServerSuite) TestHandleVariant() {
+	tests := []struct {
+		name string
+		size string
+		file string
+		code int
+	}{
+		{"OK", "100x0", "/file.png", http.StatusOK},
+		{"NoSeparator", "100", "/file.png", http.StatusBadRequest},
+		{"BadWidth", "ax100", "/file.png", http.StatusBadRequest},
+		{"BadHeight", "100xa", "/file.png", http.StatusBadRequest},
+		{"NotFound", "100x0", "/unknown.png", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Params = gin.Params{{Key: "size", Value: tt.size}, {Key: "name", Value: tt.file}}
+		c.Request, _ = http.NewRequest(http.MethodGet, "/variant/"+tt.size+tt.file, nil)
+		ss.srv.HandleVariant(c)
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+	}
+}
+
+func (ss *ServerSuite) TestHandleEdit() {
+	tests := []struct {
+		name string
+		file string
+		body string
+		code int
+	}{
+		{"OK", "/file.png/edit", `[{"op":"grayscale"}]`, http.StatusOK},
+		{"NoSuffix", "/file.png", `[{"op":"grayscale"}]`, http.StatusNotFound},
+		{"NoJSON", "/file.png/edit", `{`, http.StatusBadRequest},
+		{"NotFound", "/unknown.png/edit", `[{"op":"grayscale"}]`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Params = gin.Params{{Key: "name", Value: tt.file}}
+		c.Request, _ = http.NewRequest(http.MethodPost, "/img"+tt.file, strings.NewReader(tt.body))
+		ss.srv.HandleEdit(c)
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+	}
+}
+
+func (ss *ServerSuite) TestHandleIIIF() {
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		code     int
+		ctype    string
+		location string
+	}{
+		{"Info", "/file.png/info.json", "", http.StatusOK, "application/json; charset=utf-8", ""},
+		{"InfoLD", "/file.png/info.json", "application/ld+json", http.StatusOK, "application/ld+json", ""},
+		{"InfoNotFound", "/unknown.png/info.json", "", http.StatusNotFound, "", ""},
+		{"Image", "/dir/file.png/full/max/0/default.png", "", http.StatusOK, "image/png", ""},
+		{"BaseURI", "/file.png", "", http.StatusSeeOther, "", "/iiif/file.png/info.json"},
+		{"NoFormat", "/file.png/full/max/0/default", "", http.StatusBadRequest, "", ""},
+		{"BadFormat", "/file.png/full/max/0/default.bmp", "", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Params = gin.Params{{Key: "path", Value: tt.path}}
+		c.Request, _ = http.NewRequest(http.MethodGet, "/iiif"+tt.path, nil)
+		c.Request.Header.Set("Accept", tt.accept)
+		ss.srv.HandleIIIF(c)
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+		assert.Empty(ss.T(), resp.Header().Get("Access-Control-Allow-Origin"), tt.name)
+		if tt.ctype != "" {
+			assert.True(ss.T(), strings.HasPrefix(resp.Header().Get("Content-Type"), tt.ctype), tt.name)
+		}
+		if tt.location != "" {
+			assert.Equal(ss.T(), tt.location, resp.Header().Get("Location"), tt.name)
+		}
+	}
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	c.Params = gin.Params{{Key: "path", Value: "/file.png/info.json"}}
+	c.Request, _ = http.NewRequest(http.MethodGet, "/iiif/file.png/info.json", nil)
+	c.Request.Host = "example.com"
+	// scheme of untrusted client is ignored
+	c.Request.Header.Set("X-Forwarded-Proto", "https")
+	ss.srv.HandleIIIF(c)
+	assert.Contains(ss.T(), resp.Body.String(), `"id":"http://example.com/iiif/file.png"`)
+
+	// scheme of trusted proxy is used
+	resp = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(resp)
+	c.Params = gin.Params{{Key: "path", Value: "/file.png/info.json"}}
+	c.Request, _ = http.NewRequest(http.MethodGet, "/iiif/file.png/info.json", nil)
+	c.Request.Host = "example.com"
+	c.Request.Header.Set("X-Forwarded-Proto", "https")
+	c.Set(ginauth.ContextProxy, true)
+	ss.srv.HandleIIIF(c)
+	assert.Contains(ss.T(), resp.Body.String(), `"id":"https://example.com/iiif/file.png"`)
+}
+
+func (ss *ServerSuite) TestHandlePage() {
+	tests := []struct {
+		name string
+		page string
+		file string
+		code int
+	}{
+		{"OK", "1", "/file.png", http.StatusOK},
+		{"BadPage", "a", "/file.png", http.StatusBadRequest},
+		{"NotFound", "2", "/file.png", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Params = gin.Params{{Key: "page", Value: tt.page}, {Key: "name", Value: tt.file}}
+		c.Request, _ = http.NewRequest(http.MethodGet, "/page/"+tt.page+tt.file, nil)
+		ss.srv.HandlePage(c)
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+		if tt.code == http.StatusOK {
+			assert.Equal(ss.T(), "image/png", resp.Header().Get("Content-Type"), tt.name)
+		}
+	}
+}
+
+func (ss *ServerSuite) TestHandleSheet() {
+	tests := []struct {
+		name    string
+		method  string
+		query   string
+		body    string
+		code    int
+		message string
+	}{
+		{"Image", http.MethodGet, "?name=/file.png", "", http.StatusOK, "png"},
+		{"JSON", http.MethodPost, "?output=json", `{"names":["/file.png"]}`, http.StatusOK,
+			`{"image":"data:image/png;base64,cG5n","format":"png","width":1,"height":1,` +
+				`"tiles":{"/file.png":{"x":0,"y":0,"width":1,"height":1}}}`},
+		{"NoImages", http.MethodGet, "", "", http.StatusBadRequest, upload.ErrSheetSize},
+		{"BadSize", http.MethodGet, "?size=a", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(resp)
+		c.Request, _ = http.NewRequest(tt.method, "/sheet"+tt.query, strings.NewReader(tt.body))
+		if tt.body != "" {
+			c.Request.Header.Set("Content-Type", "application/json")
+		}
+		ss.srv.HandleSheet(c)
+		assert.Equal(ss.T(), tt.code, resp.Code, tt.name)
+		if tt.message != "" {
+			assert.Equal(ss.T(), tt.message, resp.Body.String(), tt.name)
+		}
+	}
+}
+
 func TestSuite(t *testing.T) {
 	myTest := &ServerSuite{}
 	suite.Run(t, myTest)
 }
+
+func TestSandboxSVG(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/img/*filepath", SandboxSVG(), func(c *gin.Context) { c.String(http.StatusOK, "image") })
+	for url, policy := range map[string]string{"/img/logo.svg": "sandbox", "/img/logo.SVG": "sandbox", "/img/cat.png": ""} {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, policy, resp.Header().Get("Content-Security-Policy"), url)
+	}
+}
@@ -0,0 +1,84 @@
+package ginupload
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrHotlink returned when image is requested from not allowed site
+const ErrHotlink = "hotlinking is not allowed"
+
+// refererHost returns host of request Origin or Referer header, empty if headers are not set
+func refererHost(c *gin.Context) string {
+	ref := c.GetHeader("Origin")
+	if ref == "" || ref == "null" {
+		ref = c.GetHeader("Referer")
+	}
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		// unparsable referer is not allowed
+		return "-"
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// hostAllowed checks if host matches allowlist item, "*.example.com" matches subdomains only
+func hostAllowed(host string, allow []string) bool {
+	for _, item := range allow {
+		item = strings.ToLower(item)
+		if suffix, ok := strings.CutPrefix(item, "*"); ok {
+			if strings.HasSuffix(host, suffix) && strings.HasPrefix(suffix, ".") {
+				return true
+			}
+			continue
+		}
+		if host == item {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckHotlink returns middleware which allows image requests from sites in HotlinkAllow only.
+// Requests of service own host, without Origin and Referer (if HotlinkBlockEmpty is not set)
+// and signed links of private images are always allowed.
+// Disallowed requests get HotlinkPlaceholder image or 403 if placeholder is not set.
+func (srv Service) CheckHotlink(kind int) gin.HandlerFunc {
+	cfg := srv.Config
+	return func(c *gin.Context) {
+		if len(cfg.HotlinkAllow) == 0 {
+			return
+		}
+		c.Header("Vary", "Origin, Referer")
+		host := refererHost(c)
+		if host == "" && !cfg.HotlinkBlockEmpty {
+			return
+		}
+		own, _, err := net.SplitHostPort(c.Request.Host)
+		if err != nil {
+			own = c.Request.Host
+		}
+		if host == strings.ToLower(own) || hostAllowed(host, cfg.HotlinkAllow) {
+			return
+		}
+		if checkSignature(cfg.PrivateSecret, cleanName(requestName(c, kind)), c.Request.URL.Query(), time.Now()) {
+			return
+		}
+		if cfg.HotlinkPlaceholder != "" {
+			c.Header("Cache-Control", "no-store")
+			c.File(cfg.HotlinkPlaceholder)
+			c.Abort()
+			return
+		}
+		c.String(http.StatusForbidden, ErrHotlink)
+		c.Abort()
+	}
+}
@@ -0,0 +1,74 @@
+package ginupload
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostAllowed(t *testing.T) {
+	allow := []string{"example.com", "*.Example.org"}
+	assert.True(t, hostAllowed("example.com", allow))
+	assert.False(t, hostAllowed("www.example.com", allow))
+	assert.True(t, hostAllowed("www.example.org", allow))
+	assert.False(t, hostAllowed("example.org", allow))
+	assert.False(t, hostAllowed("badexample.org", allow))
+}
+
+func TestCheckHotlink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	placeholder := filepath.Join(t.TempDir(), "hotlink.png")
+	require.NoError(t, os.WriteFile(placeholder, []byte("placeholder"), 0600))
+	srv, err := New(Config{HotlinkAllow: []string{"*.example.com"}, PrivateSecret: "secret"}, nil, &UploaderMock{})
+	require.NoError(t, err)
+	router := gin.New()
+	router.GET("/img/*filepath", srv.CheckHotlink(PrivateFile), func(c *gin.Context) { c.String(http.StatusOK, "image") })
+
+	signed := "?" + SignQuery("secret", "/cat.jpg", time.Now().Add(time.Minute)).Encode()
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		value  string
+		code   int
+		body   string
+	}{
+		{"NoReferer", "/img/cat.jpg", "", "", http.StatusOK, "image"},
+		{"Allowed", "/img/cat.jpg", "Referer", "https://www.example.com/page", http.StatusOK, "image"},
+		{"AllowedOrigin", "/img/cat.jpg", "Origin", "https://cdn.example.com", http.StatusOK, "image"},
+		{"OwnHost", "/img/cat.jpg", "Referer", "http://example.com/index.html", http.StatusOK, "image"},
+		{"Denied", "/img/cat.jpg", "Referer", "https://other.net/page", http.StatusForbidden, ErrHotlink},
+		{"BadReferer", "/img/cat.jpg", "Referer", "::", http.StatusForbidden, ErrHotlink},
+		{"Signed", "/img/cat.jpg" + signed, "Referer", "https://other.net/page", http.StatusOK, "image"},
+		{"SignedOther", "/img/dog.jpg" + signed, "Referer", "https://other.net/page", http.StatusForbidden, ErrHotlink},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tt.url, nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, tt.code, resp.Code, tt.name)
+		assert.Equal(t, tt.body, resp.Body.String(), tt.name)
+	}
+
+	// placeholder and empty referer blocking
+	srv.Config.HotlinkBlockEmpty = true
+	srv.Config.HotlinkPlaceholder = placeholder
+	router = gin.New()
+	router.GET("/img/*filepath", srv.CheckHotlink(PrivateFile), func(c *gin.Context) { c.String(http.StatusOK, "image") })
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("GET", "/img/cat.jpg", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "placeholder", resp.Body.String())
+	assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+	assert.Equal(t, "Origin, Referer", resp.Header().Get("Vary"))
+}
@@ -0,0 +1,157 @@
+package ginupload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/LeKovr/fiwes/upload"
+)
+
+const (
+	// ParamExpires holds signed link expiry (unix time) query param
+	ParamExpires = "expires"
+	// ParamSignature holds signed link signature query param
+	ParamSignature = "signature"
+
+	// ErrPrivate returned when private image is requested without valid signed link
+	ErrPrivate = "valid signed link required"
+	// ErrNoPrivate returned when private upload is requested but private secret is not set
+	ErrNoPrivate = "private images are not configured"
+)
+
+// Kinds of private image requests, used for getting image name from request
+const (
+	PrivateFile    = iota // static image file, path param "filepath"
+	PrivatePreview        // static preview file, path param "filepath"
+	PrivateTile           // static deep zoom file, path param "filepath"
+	PrivateIIIF           // IIIF request, path param "path"
+	PrivateName           // path param "name"
+)
+
+// cleanName returns image name in form used for signing
+func cleanName(name string) string {
+	return path.Clean("/" + name)
+}
+
+// linkSignature returns hex encoded HMAC-SHA256 of image name and expiry
+func linkSignature(secret, name string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(cleanName(name) + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignQuery returns query params of signed link to private image.
+// Signature is valid for all links of image (file, preview, variants etc).
+func SignQuery(secret, name string, expires time.Time) url.Values {
+	q := url.Values{}
+	q.Set(ParamExpires, strconv.FormatInt(expires.Unix(), 10))
+	q.Set(ParamSignature, linkSignature(secret, name, expires.Unix()))
+	return q
+}
+
+// SignURL returns link to private image with signature
+func SignURL(secret, link, name string, expires time.Time) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for k, v := range SignQuery(secret, name, expires) {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// checkSignature checks signed link query params of image
+func checkSignature(secret, name string, q url.Values, now time.Time) bool {
+	expires, err := strconv.ParseInt(q.Get(ParamExpires), 10, 64)
+	if err != nil || secret == "" || now.Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(linkSignature(secret, name, expires)), []byte(q.Get(ParamSignature)))
+}
+
+// requestName returns image name of request by kind
+func requestName(c *gin.Context, kind int) string {
+	switch kind {
+	case PrivatePreview:
+		// preview of non raster image has additional ext
+		name := c.Param("filepath")
+		if base, ok := strings.CutSuffix(name, upload.RasterExt); ok && upload.RasterName(base) == name {
+			return base
+		}
+		return name
+	case PrivateTile:
+		name := c.Param("filepath")
+		if base, _, ok := strings.Cut(name, upload.TileFilesSuffix+"/"); ok {
+			return base
+		}
+		return strings.TrimSuffix(name, upload.TileDescriptorExt)
+	case PrivateIIIF:
+		reqPath := strings.TrimPrefix(c.Param("path"), "/")
+		if name, ok := strings.CutSuffix(reqPath, IIIFInfoSuffix); ok {
+			return name
+		}
+		parts := strings.Split(reqPath, "/")
+		if len(parts) <= iiifImageParts {
+			return reqPath
+		}
+		return strings.Join(parts[:len(parts)-iiifImageParts], "/")
+	case PrivateName:
+		return c.Param("name")
+	}
+	return c.Param("filepath")
+}
+
+// RequirePrivate returns middleware which allows access to private images by signed links only.
+// Requests of unknown images are passed as is.
+func (srv Service) RequirePrivate(kind int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := cleanName(requestName(c, kind))
+		meta, err := srv.up.Meta(name)
+		if err != nil || !meta.Private || checkSignature(srv.Config.PrivateSecret, name, c.Request.URL.Query(), time.Now()) {
+			return
+		}
+		c.String(http.StatusForbidden, ErrPrivate)
+		c.Abort()
+	}
+}
+
+// privateLink returns link signed for PrivateTTL if image is private
+func (srv Service) privateLink(link, name string, private bool) string {
+	if !private {
+		return link
+	}
+	if rv, err := SignURL(srv.Config.PrivateSecret, link, name, time.Now().Add(srv.Config.PrivateTTL)); err == nil {
+		return rv
+	}
+	return link
+}
+
+// privateAttrs returns request attributes with private flag.
+// Private upload is rejected if signed links are not configured.
+func (srv Service) privateAttrs(c *gin.Context, private bool) (upload.Attrs, error) {
+	attrs := requestAttrs(c)
+	attrs.Private = private
+	if private && srv.Config.PrivateSecret == "" {
+		return attrs, upload.NewHTTPError(http.StatusBadRequest, errors.New(ErrNoPrivate))
+	}
+	return attrs, nil
+}
+
+// privateFlag parses private flag request value, invalid value means false
+func privateFlag(value string) bool {
+	rv, _ := strconv.ParseBool(value)
+	return rv
+}
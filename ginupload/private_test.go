@@ -0,0 +1,115 @@
+package ginupload
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/LeKovr/fiwes/upload"
+)
+
+func TestSignURL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	link, err := SignURL("secret", "/preview/alice/cat.jpg?v=1", "/alice/cat.jpg", now.Add(time.Minute))
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", link, nil)
+	q := req.URL.Query()
+	assert.Equal(t, "1", q.Get("v"))
+	assert.True(t, checkSignature("secret", "/alice/cat.jpg", q, now))
+	assert.True(t, checkSignature("secret", "alice//cat.jpg", q, now))
+	assert.False(t, checkSignature("secret", "/alice/dog.jpg", q, now))
+	assert.False(t, checkSignature("other", "/alice/cat.jpg", q, now))
+	assert.False(t, checkSignature("", "/alice/cat.jpg", q, now))
+	assert.False(t, checkSignature("secret", "/alice/cat.jpg", q, now.Add(time.Hour)))
+	q.Set(ParamExpires, "1800000000")
+	assert.False(t, checkSignature("secret", "/alice/cat.jpg", q, now))
+}
+
+func TestRequirePrivate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv, err := New(Config{PrivateSecret: "secret", PrivateTTL: time.Hour}, nil, &UploaderMock{
+		MetaFunc: func(name string) (*upload.Meta, error) {
+			switch name {
+			case "/logo.svg":
+				return &upload.Meta{Name: name, Private: true}, nil
+			case "/cat.jpg":
+				return &upload.Meta{Name: name}, nil
+			}
+			return nil, upload.NewHTTPError(http.StatusNotFound, errors.New(upload.ErrNotFound))
+		},
+	})
+	require.NoError(t, err)
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	router := gin.New()
+	router.GET("/img/*filepath", srv.RequirePrivate(PrivateFile), ok)
+	router.GET("/preview/*filepath", srv.RequirePrivate(PrivatePreview), ok)
+	router.GET("/tiles/*filepath", srv.RequirePrivate(PrivateTile), ok)
+	router.GET("/iiif/*path", srv.RequirePrivate(PrivateIIIF), ok)
+	router.GET("/variant/:size/*name", srv.RequirePrivate(PrivateName), ok)
+
+	signed := "?" + SignQuery("secret", "/logo.svg", time.Now().Add(time.Minute)).Encode()
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/img/cat.jpg", http.StatusOK},
+		{"/img/none.jpg", http.StatusOK},
+		{"/img/logo.svg", http.StatusForbidden},
+		{"/img/logo.svg?expires=1&signature=x", http.StatusForbidden},
+		{"/img/logo.svg" + signed, http.StatusOK},
+		{"/preview/logo.svg.png", http.StatusForbidden},
+		{"/preview/logo.svg.png" + signed, http.StatusOK},
+		{"/tiles/logo.svg.dzi", http.StatusForbidden},
+		{"/tiles/logo.svg_files/0/0_0.jpg", http.StatusForbidden},
+		{"/tiles/logo.svg_files/0/0_0.jpg" + signed, http.StatusOK},
+		{"/iiif/logo.svg/info.json", http.StatusForbidden},
+		{"/iiif/logo.svg/full/max/0/default.png", http.StatusForbidden},
+		{"/iiif/logo.svg/full/max/0/default.png" + signed, http.StatusOK},
+		{"/variant/10x0/logo.svg", http.StatusForbidden},
+		{"/variant/10x0/cat.jpg", http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest("GET", tt.url, nil))
+		assert.Equal(t, tt.code, resp.Code, tt.url)
+	}
+}
+
+func TestPrivateUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mock := &UploaderMock{
+		HandleURLFunc: func(url string, attrs upload.Attrs) (*string, error) {
+			n := "/logo.svg"
+			return &n, nil
+		},
+	}
+	srv, err := New(Config{PreviewPath: "/preview", PrivateTTL: time.Hour}, nil, mock)
+	require.NoError(t, err)
+
+	// private uploads are disabled without secret
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	c.Request = httptest.NewRequest("GET", "/upload?url=https://example.com/logo.svg&private=1", nil)
+	srv.HandleURL(c)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, ErrNoPrivate, resp.Body.String())
+
+	srv.Config.PrivateSecret = "secret"
+	resp = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(resp)
+	c.Request = httptest.NewRequest("GET", "/upload?url=https://example.com/logo.svg&private=true", nil)
+	srv.HandleURL(c)
+	require.Equal(t, http.StatusFound, resp.Code)
+	assert.True(t, mock.HandleURLCalls()[0].Attrs.Private)
+	link := resp.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(link, "/preview/logo.svg.png?expires="), link)
+	req := httptest.NewRequest("GET", link, nil)
+	assert.True(t, checkSignature("secret", "/logo.svg", req.URL.Query(), time.Now()))
+}
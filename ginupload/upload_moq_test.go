@@ -4,14 +4,28 @@
 package ginupload
 
 import (
+	"github.com/LeKovr/fiwes/upload"
 	"mime/multipart"
 	"sync"
 )
 
 var (
+	lockUploaderMockDelete          sync.RWMutex
+	lockUploaderMockEdit            sync.RWMutex
 	lockUploaderMockHandleBase64    sync.RWMutex
 	lockUploaderMockHandleMultiPart sync.RWMutex
 	lockUploaderMockHandleURL       sync.RWMutex
+	lockUploaderMockIIIFImage       sync.RWMutex
+	lockUploaderMockIIIFInfo        sync.RWMutex
+	lockUploaderMockList            sync.RWMutex
+	lockUploaderMockMeta            sync.RWMutex
+	lockUploaderMockOwnedMeta       sync.RWMutex
+	lockUploaderMockPage            sync.RWMutex
+	lockUploaderMockSheet           sync.RWMutex
+	lockUploaderMockSimilar         sync.RWMutex
+	lockUploaderMockSimilarFile     sync.RWMutex
+	lockUploaderMockUsage           sync.RWMutex
+	lockUploaderMockVariant         sync.RWMutex
 )
 
 // Ensure, that UploaderMock does implement Uploader.
@@ -24,15 +38,54 @@ var _ Uploader = &UploaderMock{}
 //
 //         // make and configure a mocked Uploader
 //         mockedUploader := &UploaderMock{
-//             HandleBase64Func: func(data string, name string) (*string, error) {
+//             DeleteFunc: func(name string, attrs upload.Attrs) error {
+// 	               panic("mock out the Delete method")
+//             },
+//             EditFunc: func(name string, ops []upload.Operation, attrs upload.Attrs) (*string, error) {
+// 	               panic("mock out the Edit method")
+//             },
+//             HandleBase64Func: func(data string, name string, attrs upload.Attrs) (*string, error) {
 // 	               panic("mock out the HandleBase64 method")
 //             },
-//             HandleMultiPartFunc: func(form *multipart.Form) (*string, error) {
+//             HandleMultiPartFunc: func(form *multipart.Form, attrs upload.Attrs) (*string, error) {
 // 	               panic("mock out the HandleMultiPart method")
 //             },
-//             HandleURLFunc: func(url string) (*string, error) {
+//             HandleURLFunc: func(url string, attrs upload.Attrs) (*string, error) {
 // 	               panic("mock out the HandleURL method")
 //             },
+//             IIIFImageFunc: func(name string, params upload.IIIFParams) ([]byte, error) {
+// 	               panic("mock out the IIIFImage method")
+//             },
+//             IIIFInfoFunc: func(name string) (*upload.IIIFInfo, error) {
+// 	               panic("mock out the IIIFInfo method")
+//             },
+//             ListFunc: func(attrs upload.Attrs) ([]upload.Meta, error) {
+// 	               panic("mock out the List method")
+//             },
+//             MetaFunc: func(name string) (*upload.Meta, error) {
+// 	               panic("mock out the Meta method")
+//             },
+//             OwnedMetaFunc: func(name string, attrs upload.Attrs) (*upload.Meta, error) {
+// 	               panic("mock out the OwnedMeta method")
+//             },
+//             PageFunc: func(name string, page int) ([]byte, error) {
+// 	               panic("mock out the Page method")
+//             },
+//             SheetFunc: func(req upload.SheetRequest, attrs upload.Attrs) (*upload.Sheet, error) {
+// 	               panic("mock out the Sheet method")
+//             },
+//             SimilarFunc: func(name string, distance int, attrs upload.Attrs) ([]upload.Match, error) {
+// 	               panic("mock out the Similar method")
+//             },
+//             SimilarFileFunc: func(form *multipart.Form, distance int, attrs upload.Attrs) ([]upload.Match, error) {
+// 	               panic("mock out the SimilarFile method")
+//             },
+//             UsageFunc: func(attrs upload.Attrs) (*upload.Usage, error) {
+// 	               panic("mock out the Usage method")
+//             },
+//             VariantFunc: func(name string, width int, height int) (string, error) {
+// 	               panic("mock out the Variant method")
+//             },
 //         }
 //
 //         // use mockedUploader in code that requires Uploader
@@ -40,65 +93,279 @@ var _ Uploader = &UploaderMock{}
 //
 //     }
 type UploaderMock struct {
+	// DeleteFunc mocks the Delete method.
+	DeleteFunc func(name string, attrs upload.Attrs) error
+
+	// EditFunc mocks the Edit method.
+	EditFunc func(name string, ops []upload.Operation, attrs upload.Attrs) (*string, error)
+
 	// HandleBase64Func mocks the HandleBase64 method.
-	HandleBase64Func func(data string, name string) (*string, error)
+	HandleBase64Func func(data string, name string, attrs upload.Attrs) (*string, error)
 
 	// HandleMultiPartFunc mocks the HandleMultiPart method.
-	HandleMultiPartFunc func(form *multipart.Form) (*string, error)
+	HandleMultiPartFunc func(form *multipart.Form, attrs upload.Attrs) (*string, error)
 
 	// HandleURLFunc mocks the HandleURL method.
-	HandleURLFunc func(url string) (*string, error)
+	HandleURLFunc func(url string, attrs upload.Attrs) (*string, error)
+
+	// IIIFImageFunc mocks the IIIFImage method.
+	IIIFImageFunc func(name string, params upload.IIIFParams) ([]byte, error)
+
+	// IIIFInfoFunc mocks the IIIFInfo method.
+	IIIFInfoFunc func(name string) (*upload.IIIFInfo, error)
+
+	// ListFunc mocks the List method.
+	ListFunc func(attrs upload.Attrs) ([]upload.Meta, error)
+
+	// MetaFunc mocks the Meta method.
+	MetaFunc func(name string) (*upload.Meta, error)
+
+	// OwnedMetaFunc mocks the OwnedMeta method.
+	OwnedMetaFunc func(name string, attrs upload.Attrs) (*upload.Meta, error)
+
+	// PageFunc mocks the Page method.
+	PageFunc func(name string, page int) ([]byte, error)
+
+	// SheetFunc mocks the Sheet method.
+	SheetFunc func(req upload.SheetRequest, attrs upload.Attrs) (*upload.Sheet, error)
+
+	// SimilarFunc mocks the Similar method.
+	SimilarFunc func(name string, distance int, attrs upload.Attrs) ([]upload.Match, error)
+
+	// SimilarFileFunc mocks the SimilarFile method.
+	SimilarFileFunc func(form *multipart.Form, distance int, attrs upload.Attrs) ([]upload.Match, error)
+
+	// UsageFunc mocks the Usage method.
+	UsageFunc func(attrs upload.Attrs) (*upload.Usage, error)
+
+	// VariantFunc mocks the Variant method.
+	VariantFunc func(name string, width int, height int) (string, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// Delete holds details about calls to the Delete method.
+		Delete []struct {
+			// Name is the name argument value.
+			Name string
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
+		}
+		// Edit holds details about calls to the Edit method.
+		Edit []struct {
+			// Name is the name argument value.
+			Name string
+			// Ops is the ops argument value.
+			Ops []upload.Operation
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
+		}
 		// HandleBase64 holds details about calls to the HandleBase64 method.
 		HandleBase64 []struct {
 			// Data is the data argument value.
 			Data string
 			// Name is the name argument value.
 			Name string
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
 		}
 		// HandleMultiPart holds details about calls to the HandleMultiPart method.
 		HandleMultiPart []struct {
 			// Form is the form argument value.
 			Form *multipart.Form
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
 		}
 		// HandleURL holds details about calls to the HandleURL method.
 		HandleURL []struct {
 			// URL is the url argument value.
 			URL string
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
+		}
+		// IIIFImage holds details about calls to the IIIFImage method.
+		IIIFImage []struct {
+			// Name is the name argument value.
+			Name string
+			// Params is the params argument value.
+			Params upload.IIIFParams
+		}
+		// IIIFInfo holds details about calls to the IIIFInfo method.
+		IIIFInfo []struct {
+			// Name is the name argument value.
+			Name string
+		}
+		// List holds details about calls to the List method.
+		List []struct {
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
+		}
+		// Meta holds details about calls to the Meta method.
+		Meta []struct {
+			// Name is the name argument value.
+			Name string
+		}
+		// OwnedMeta holds details about calls to the OwnedMeta method.
+		OwnedMeta []struct {
+			// Name is the name argument value.
+			Name string
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
+		}
+		// Page holds details about calls to the Page method.
+		Page []struct {
+			// Name is the name argument value.
+			Name string
+			// Page is the page argument value.
+			Page int
+		}
+		// Sheet holds details about calls to the Sheet method.
+		Sheet []struct {
+			// Req is the req argument value.
+			Req upload.SheetRequest
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
+		}
+		// Similar holds details about calls to the Similar method.
+		Similar []struct {
+			// Name is the name argument value.
+			Name string
+			// Distance is the distance argument value.
+			Distance int
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
 		}
+		// SimilarFile holds details about calls to the SimilarFile method.
+		SimilarFile []struct {
+			// Form is the form argument value.
+			Form *multipart.Form
+			// Distance is the distance argument value.
+			Distance int
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
+		}
+		// Usage holds details about calls to the Usage method.
+		Usage []struct {
+			// Attrs is the attrs argument value.
+			Attrs upload.Attrs
+		}
+		// Variant holds details about calls to the Variant method.
+		Variant []struct {
+			// Name is the name argument value.
+			Name string
+			// Width is the width argument value.
+			Width int
+			// Height is the height argument value.
+			Height int
+		}
+	}
+}
+
+// Delete calls DeleteFunc.
+func (mock *UploaderMock) Delete(name string, attrs upload.Attrs) error {
+	if mock.DeleteFunc == nil {
+		panic("UploaderMock.DeleteFunc: method is nil but Uploader.Delete was just called")
+	}
+	callInfo := struct {
+		Name  string
+		Attrs upload.Attrs
+	}{
+		Name:  name,
+		Attrs: attrs,
+	}
+	lockUploaderMockDelete.Lock()
+	mock.calls.Delete = append(mock.calls.Delete, callInfo)
+	lockUploaderMockDelete.Unlock()
+	return mock.DeleteFunc(name, attrs)
+}
+
+// DeleteCalls gets all the calls that were made to Delete.
+// Check the length with:
+//     len(mockedUploader.DeleteCalls())
+func (mock *UploaderMock) DeleteCalls() []struct {
+	Name  string
+	Attrs upload.Attrs
+} {
+	var calls []struct {
+		Name  string
+		Attrs upload.Attrs
 	}
+	lockUploaderMockDelete.RLock()
+	calls = mock.calls.Delete
+	lockUploaderMockDelete.RUnlock()
+	return calls
+}
+
+// Edit calls EditFunc.
+func (mock *UploaderMock) Edit(name string, ops []upload.Operation, attrs upload.Attrs) (*string, error) {
+	if mock.EditFunc == nil {
+		panic("UploaderMock.EditFunc: method is nil but Uploader.Edit was just called")
+	}
+	callInfo := struct {
+		Name  string
+		Ops   []upload.Operation
+		Attrs upload.Attrs
+	}{
+		Name:  name,
+		Ops:   ops,
+		Attrs: attrs,
+	}
+	lockUploaderMockEdit.Lock()
+	mock.calls.Edit = append(mock.calls.Edit, callInfo)
+	lockUploaderMockEdit.Unlock()
+	return mock.EditFunc(name, ops, attrs)
+}
+
+// EditCalls gets all the calls that were made to Edit.
+// Check the length with:
+//     len(mockedUploader.EditCalls())
+func (mock *UploaderMock) EditCalls() []struct {
+	Name  string
+	Ops   []upload.Operation
+	Attrs upload.Attrs
+} {
+	var calls []struct {
+		Name  string
+		Ops   []upload.Operation
+		Attrs upload.Attrs
+	}
+	lockUploaderMockEdit.RLock()
+	calls = mock.calls.Edit
+	lockUploaderMockEdit.RUnlock()
+	return calls
 }
 
 // HandleBase64 calls HandleBase64Func.
-func (mock *UploaderMock) HandleBase64(data string, name string) (*string, error) {
+func (mock *UploaderMock) HandleBase64(data string, name string, attrs upload.Attrs) (*string, error) {
 	if mock.HandleBase64Func == nil {
 		panic("UploaderMock.HandleBase64Func: method is nil but Uploader.HandleBase64 was just called")
 	}
 	callInfo := struct {
-		Data string
-		Name string
+		Data  string
+		Name  string
+		Attrs upload.Attrs
 	}{
-		Data: data,
-		Name: name,
+		Data:  data,
+		Name:  name,
+		Attrs: attrs,
 	}
 	lockUploaderMockHandleBase64.Lock()
 	mock.calls.HandleBase64 = append(mock.calls.HandleBase64, callInfo)
 	lockUploaderMockHandleBase64.Unlock()
-	return mock.HandleBase64Func(data, name)
+	return mock.HandleBase64Func(data, name, attrs)
 }
 
 // HandleBase64Calls gets all the calls that were made to HandleBase64.
 // Check the length with:
 //     len(mockedUploader.HandleBase64Calls())
 func (mock *UploaderMock) HandleBase64Calls() []struct {
-	Data string
-	Name string
+	Data  string
+	Name  string
+	Attrs upload.Attrs
 } {
 	var calls []struct {
-		Data string
-		Name string
+		Data  string
+		Name  string
+		Attrs upload.Attrs
 	}
 	lockUploaderMockHandleBase64.RLock()
 	calls = mock.calls.HandleBase64
@@ -107,29 +374,33 @@ func (mock *UploaderMock) HandleBase64Calls() []struct {
 }
 
 // HandleMultiPart calls HandleMultiPartFunc.
-func (mock *UploaderMock) HandleMultiPart(form *multipart.Form) (*string, error) {
+func (mock *UploaderMock) HandleMultiPart(form *multipart.Form, attrs upload.Attrs) (*string, error) {
 	if mock.HandleMultiPartFunc == nil {
 		panic("UploaderMock.HandleMultiPartFunc: method is nil but Uploader.HandleMultiPart was just called")
 	}
 	callInfo := struct {
-		Form *multipart.Form
+		Form  *multipart.Form
+		Attrs upload.Attrs
 	}{
-		Form: form,
+		Form:  form,
+		Attrs: attrs,
 	}
 	lockUploaderMockHandleMultiPart.Lock()
 	mock.calls.HandleMultiPart = append(mock.calls.HandleMultiPart, callInfo)
 	lockUploaderMockHandleMultiPart.Unlock()
-	return mock.HandleMultiPartFunc(form)
+	return mock.HandleMultiPartFunc(form, attrs)
 }
 
 // HandleMultiPartCalls gets all the calls that were made to HandleMultiPart.
 // Check the length with:
 //     len(mockedUploader.HandleMultiPartCalls())
 func (mock *UploaderMock) HandleMultiPartCalls() []struct {
-	Form *multipart.Form
+	Form  *multipart.Form
+	Attrs upload.Attrs
 } {
 	var calls []struct {
-		Form *multipart.Form
+		Form  *multipart.Form
+		Attrs upload.Attrs
 	}
 	lockUploaderMockHandleMultiPart.RLock()
 	calls = mock.calls.HandleMultiPart
@@ -138,32 +409,417 @@ func (mock *UploaderMock) HandleMultiPartCalls() []struct {
 }
 
 // HandleURL calls HandleURLFunc.
-func (mock *UploaderMock) HandleURL(url string) (*string, error) {
+func (mock *UploaderMock) HandleURL(url string, attrs upload.Attrs) (*string, error) {
 	if mock.HandleURLFunc == nil {
 		panic("UploaderMock.HandleURLFunc: method is nil but Uploader.HandleURL was just called")
 	}
 	callInfo := struct {
-		URL string
+		URL   string
+		Attrs upload.Attrs
 	}{
-		URL: url,
+		URL:   url,
+		Attrs: attrs,
 	}
 	lockUploaderMockHandleURL.Lock()
 	mock.calls.HandleURL = append(mock.calls.HandleURL, callInfo)
 	lockUploaderMockHandleURL.Unlock()
-	return mock.HandleURLFunc(url)
+	return mock.HandleURLFunc(url, attrs)
 }
 
 // HandleURLCalls gets all the calls that were made to HandleURL.
 // Check the length with:
 //     len(mockedUploader.HandleURLCalls())
 func (mock *UploaderMock) HandleURLCalls() []struct {
-	URL string
+	URL   string
+	Attrs upload.Attrs
 } {
 	var calls []struct {
-		URL string
+		URL   string
+		Attrs upload.Attrs
 	}
 	lockUploaderMockHandleURL.RLock()
 	calls = mock.calls.HandleURL
 	lockUploaderMockHandleURL.RUnlock()
 	return calls
 }
+
+// IIIFImage calls IIIFImageFunc.
+func (mock *UploaderMock) IIIFImage(name string, params upload.IIIFParams) ([]byte, error) {
+	if mock.IIIFImageFunc == nil {
+		panic("UploaderMock.IIIFImageFunc: method is nil but Uploader.IIIFImage was just called")
+	}
+	callInfo := struct {
+		Name   string
+		Params upload.IIIFParams
+	}{
+		Name:   name,
+		Params: params,
+	}
+	lockUploaderMockIIIFImage.Lock()
+	mock.calls.IIIFImage = append(mock.calls.IIIFImage, callInfo)
+	lockUploaderMockIIIFImage.Unlock()
+	return mock.IIIFImageFunc(name, params)
+}
+
+// IIIFImageCalls gets all the calls that were made to IIIFImage.
+// Check the length with:
+//     len(mockedUploader.IIIFImageCalls())
+func (mock *UploaderMock) IIIFImageCalls() []struct {
+	Name   string
+	Params upload.IIIFParams
+} {
+	var calls []struct {
+		Name   string
+		Params upload.IIIFParams
+	}
+	lockUploaderMockIIIFImage.RLock()
+	calls = mock.calls.IIIFImage
+	lockUploaderMockIIIFImage.RUnlock()
+	return calls
+}
+
+// IIIFInfo calls IIIFInfoFunc.
+func (mock *UploaderMock) IIIFInfo(name string) (*upload.IIIFInfo, error) {
+	if mock.IIIFInfoFunc == nil {
+		panic("UploaderMock.IIIFInfoFunc: method is nil but Uploader.IIIFInfo was just called")
+	}
+	callInfo := struct {
+		Name string
+	}{
+		Name: name,
+	}
+	lockUploaderMockIIIFInfo.Lock()
+	mock.calls.IIIFInfo = append(mock.calls.IIIFInfo, callInfo)
+	lockUploaderMockIIIFInfo.Unlock()
+	return mock.IIIFInfoFunc(name)
+}
+
+// IIIFInfoCalls gets all the calls that were made to IIIFInfo.
+// Check the length with:
+//     len(mockedUploader.IIIFInfoCalls())
+func (mock *UploaderMock) IIIFInfoCalls() []struct {
+	Name string
+} {
+	var calls []struct {
+		Name string
+	}
+	lockUploaderMockIIIFInfo.RLock()
+	calls = mock.calls.IIIFInfo
+	lockUploaderMockIIIFInfo.RUnlock()
+	return calls
+}
+
+// List calls ListFunc.
+func (mock *UploaderMock) List(attrs upload.Attrs) ([]upload.Meta, error) {
+	if mock.ListFunc == nil {
+		panic("UploaderMock.ListFunc: method is nil but Uploader.List was just called")
+	}
+	callInfo := struct {
+		Attrs upload.Attrs
+	}{
+		Attrs: attrs,
+	}
+	lockUploaderMockList.Lock()
+	mock.calls.List = append(mock.calls.List, callInfo)
+	lockUploaderMockList.Unlock()
+	return mock.ListFunc(attrs)
+}
+
+// ListCalls gets all the calls that were made to List.
+// Check the length with:
+//     len(mockedUploader.ListCalls())
+func (mock *UploaderMock) ListCalls() []struct {
+	Attrs upload.Attrs
+} {
+	var calls []struct {
+		Attrs upload.Attrs
+	}
+	lockUploaderMockList.RLock()
+	calls = mock.calls.List
+	lockUploaderMockList.RUnlock()
+	return calls
+}
+
+// Meta calls MetaFunc.
+func (mock *UploaderMock) Meta(name string) (*upload.Meta, error) {
+	if mock.MetaFunc == nil {
+		panic("UploaderMock.MetaFunc: method is nil but Uploader.Meta was just called")
+	}
+	callInfo := struct {
+		Name string
+	}{
+		Name: name,
+	}
+	lockUploaderMockMeta.Lock()
+	mock.calls.Meta = append(mock.calls.Meta, callInfo)
+	lockUploaderMockMeta.Unlock()
+	return mock.MetaFunc(name)
+}
+
+// MetaCalls gets all the calls that were made to Meta.
+// Check the length with:
+//     len(mockedUploader.MetaCalls())
+func (mock *UploaderMock) MetaCalls() []struct {
+	Name string
+} {
+	var calls []struct {
+		Name string
+	}
+	lockUploaderMockMeta.RLock()
+	calls = mock.calls.Meta
+	lockUploaderMockMeta.RUnlock()
+	return calls
+}
+
+// OwnedMeta calls OwnedMetaFunc.
+func (mock *UploaderMock) OwnedMeta(name string, attrs upload.Attrs) (*upload.Meta, error) {
+	if mock.OwnedMetaFunc == nil {
+		panic("UploaderMock.OwnedMetaFunc: method is nil but Uploader.OwnedMeta was just called")
+	}
+	callInfo := struct {
+		Name  string
+		Attrs upload.Attrs
+	}{
+		Name:  name,
+		Attrs: attrs,
+	}
+	lockUploaderMockOwnedMeta.Lock()
+	mock.calls.OwnedMeta = append(mock.calls.OwnedMeta, callInfo)
+	lockUploaderMockOwnedMeta.Unlock()
+	return mock.OwnedMetaFunc(name, attrs)
+}
+
+// OwnedMetaCalls gets all the calls that were made to OwnedMeta.
+// Check the length with:
+//     len(mockedUploader.OwnedMetaCalls())
+func (mock *UploaderMock) OwnedMetaCalls() []struct {
+	Name  string
+	Attrs upload.Attrs
+} {
+	var calls []struct {
+		Name  string
+		Attrs upload.Attrs
+	}
+	lockUploaderMockOwnedMeta.RLock()
+	calls = mock.calls.OwnedMeta
+	lockUploaderMockOwnedMeta.RUnlock()
+	return calls
+}
+
+// Page calls PageFunc.
+func (mock *UploaderMock) Page(name string, page int) ([]byte, error) {
+	if mock.PageFunc == nil {
+		panic("UploaderMock.PageFunc: method is nil but Uploader.Page was just called")
+	}
+	callInfo := struct {
+		Name string
+		Page int
+	}{
+		Name: name,
+		Page: page,
+	}
+	lockUploaderMockPage.Lock()
+	mock.calls.Page = append(mock.calls.Page, callInfo)
+	lockUploaderMockPage.Unlock()
+	return mock.PageFunc(name, page)
+}
+
+// PageCalls gets all the calls that were made to Page.
+// Check the length with:
+//     len(mockedUploader.PageCalls())
+func (mock *UploaderMock) PageCalls() []struct {
+	Name string
+	Page int
+} {
+	var calls []struct {
+		Name string
+		Page int
+	}
+	lockUploaderMockPage.RLock()
+	calls = mock.calls.Page
+	lockUploaderMockPage.RUnlock()
+	return calls
+}
+
+// Sheet calls SheetFunc.
+func (mock *UploaderMock) Sheet(req upload.SheetRequest, attrs upload.Attrs) (*upload.Sheet, error) {
+	if mock.SheetFunc == nil {
+		panic("UploaderMock.SheetFunc: method is nil but Uploader.Sheet was just called")
+	}
+	callInfo := struct {
+		Req   upload.SheetRequest
+		Attrs upload.Attrs
+	}{
+		Req:   req,
+		Attrs: attrs,
+	}
+	lockUploaderMockSheet.Lock()
+	mock.calls.Sheet = append(mock.calls.Sheet, callInfo)
+	lockUploaderMockSheet.Unlock()
+	return mock.SheetFunc(req, attrs)
+}
+
+// SheetCalls gets all the calls that were made to Sheet.
+// Check the length with:
+//     len(mockedUploader.SheetCalls())
+func (mock *UploaderMock) SheetCalls() []struct {
+	Req   upload.SheetRequest
+	Attrs upload.Attrs
+} {
+	var calls []struct {
+		Req   upload.SheetRequest
+		Attrs upload.Attrs
+	}
+	lockUploaderMockSheet.RLock()
+	calls = mock.calls.Sheet
+	lockUploaderMockSheet.RUnlock()
+	return calls
+}
+
+// Similar calls SimilarFunc.
+func (mock *UploaderMock) Similar(name string, distance int, attrs upload.Attrs) ([]upload.Match, error) {
+	if mock.SimilarFunc == nil {
+		panic("UploaderMock.SimilarFunc: method is nil but Uploader.Similar was just called")
+	}
+	callInfo := struct {
+		Name     string
+		Distance int
+		Attrs    upload.Attrs
+	}{
+		Name:     name,
+		Distance: distance,
+		Attrs:    attrs,
+	}
+	lockUploaderMockSimilar.Lock()
+	mock.calls.Similar = append(mock.calls.Similar, callInfo)
+	lockUploaderMockSimilar.Unlock()
+	return mock.SimilarFunc(name, distance, attrs)
+}
+
+// SimilarCalls gets all the calls that were made to Similar.
+// Check the length with:
+//     len(mockedUploader.SimilarCalls())
+func (mock *UploaderMock) SimilarCalls() []struct {
+	Name     string
+	Distance int
+	Attrs    upload.Attrs
+} {
+	var calls []struct {
+		Name     string
+		Distance int
+		Attrs    upload.Attrs
+	}
+	lockUploaderMockSimilar.RLock()
+	calls = mock.calls.Similar
+	lockUploaderMockSimilar.RUnlock()
+	return calls
+}
+
+// SimilarFile calls SimilarFileFunc.
+func (mock *UploaderMock) SimilarFile(form *multipart.Form, distance int, attrs upload.Attrs) ([]upload.Match, error) {
+	if mock.SimilarFileFunc == nil {
+		panic("UploaderMock.SimilarFileFunc: method is nil but Uploader.SimilarFile was just called")
+	}
+	callInfo := struct {
+		Form     *multipart.Form
+		Distance int
+		Attrs    upload.Attrs
+	}{
+		Form:     form,
+		Distance: distance,
+		Attrs:    attrs,
+	}
+	lockUploaderMockSimilarFile.Lock()
+	mock.calls.SimilarFile = append(mock.calls.SimilarFile, callInfo)
+	lockUploaderMockSimilarFile.Unlock()
+	return mock.SimilarFileFunc(form, distance, attrs)
+}
+
+// SimilarFileCalls gets all the calls that were made to SimilarFile.
+// Check the length with:
+//     len(mockedUploader.SimilarFileCalls())
+func (mock *UploaderMock) SimilarFileCalls() []struct {
+	Form     *multipart.Form
+	Distance int
+	Attrs    upload.Attrs
+} {
+	var calls []struct {
+		Form     *multipart.Form
+		Distance int
+		Attrs    upload.Attrs
+	}
+	lockUploaderMockSimilarFile.RLock()
+	calls = mock.calls.SimilarFile
+	lockUploaderMockSimilarFile.RUnlock()
+	return calls
+}
+
+// Usage calls UsageFunc.
+func (mock *UploaderMock) Usage(attrs upload.Attrs) (*upload.Usage, error) {
+	if mock.UsageFunc == nil {
+		panic("UploaderMock.UsageFunc: method is nil but Uploader.Usage was just called")
+	}
+	callInfo := struct {
+		Attrs upload.Attrs
+	}{
+		Attrs: attrs,
+	}
+	lockUploaderMockUsage.Lock()
+	mock.calls.Usage = append(mock.calls.Usage, callInfo)
+	lockUploaderMockUsage.Unlock()
+	return mock.UsageFunc(attrs)
+}
+
+// UsageCalls gets all the calls that were made to Usage.
+// Check the length with:
+//     len(mockedUploader.UsageCalls())
+func (mock *UploaderMock) UsageCalls() []struct {
+	Attrs upload.Attrs
+} {
+	var calls []struct {
+		Attrs upload.Attrs
+	}
+	lockUploaderMockUsage.RLock()
+	calls = mock.calls.Usage
+	lockUploaderMockUsage.RUnlock()
+	return calls
+}
+
+// Variant calls VariantFunc.
+func (mock *UploaderMock) Variant(name string, width int, height int) (string, error) {
+	if mock.VariantFunc == nil {
+		panic("UploaderMock.VariantFunc: method is nil but Uploader.Variant was just called")
+	}
+	callInfo := struct {
+		Name   string
+		Width  int
+		Height int
+	}{
+		Name:   name,
+		Width:  width,
+		Height: height,
+	}
+	lockUploaderMockVariant.Lock()
+	mock.calls.Variant = append(mock.calls.Variant, callInfo)
+	lockUploaderMockVariant.Unlock()
+	return mock.VariantFunc(name, width, height)
+}
+
+// VariantCalls gets all the calls that were made to Variant.
+// Check the length with:
+//     len(mockedUploader.VariantCalls())
+func (mock *UploaderMock) VariantCalls() []struct {
+	Name   string
+	Width  int
+	Height int
+} {
+	var calls []struct {
+		Name   string
+		Width  int
+		Height int
+	}
+	lockUploaderMockVariant.RLock()
+	calls = mock.calls.Variant
+	lockUploaderMockVariant.RUnlock()
+	return calls
+}
@@ -9,13 +9,17 @@ require github.com/quic-go/quic-go v0.57.0 // indirect
 require golang.org/x/crypto v0.45.0 // indirect
 
 // Dependabot alerts #8, #15, #16, #21
-require golang.org/x/image v0.25.0 // indirect
+require golang.org/x/image v0.25.0
 
 require (
+	github.com/HugoSmits86/nativewebp v1.1.0
 	github.com/birkirb/loggers-mapper-logrus v0.0.0-20180326232643-461f2d8e6f72
+	github.com/buckket/go-blurhash v1.1.0
 	github.com/gin-gonic/gin v1.11.0
 	github.com/jessevdk/go-flags v1.6.1
 	github.com/sirupsen/logrus v1.9.3
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef
 	github.com/stretchr/testify v1.11.1
 	github.com/sunshineplan/imgconv v1.1.14
 	github.com/udhos/equalfile v0.3.0
@@ -23,7 +27,6 @@ require (
 )
 
 require (
-	github.com/HugoSmits86/nativewebp v1.1.0 // indirect
 	github.com/bytedance/sonic v1.14.0 // indirect
 	github.com/bytedance/sonic/loader v0.3.0 // indirect
 	github.com/cloudwego/base64x v0.1.6 // indirect
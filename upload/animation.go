@@ -0,0 +1,270 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+
+	"github.com/sunshineplan/imgconv"
+	"golang.org/x/image/webp"
+)
+
+const (
+	// AnimationStatic means preview of animated image is its first frame
+	AnimationStatic = "static"
+	// AnimationAnimated means preview of animated image contains all resized frames
+	AnimationAnimated = "animated"
+
+	// ErrBadGIF returned when GIF block structure is broken
+	ErrBadGIF = "gif: invalid format"
+	// ErrBadWebP returned when WebP chunk structure is broken
+	ErrBadWebP = "webp: invalid format"
+
+	gifHeaderLen  = 6
+	gifScreenLen  = 7
+	gifImageLen   = 9
+	gifExtension  = 0x21
+	gifImage      = 0x2C
+	gifTrailer    = 0x3B
+	gifColorTable = 0x80
+
+	webpHeaderLen = 12
+	webpChunkLen  = 8
+	webpFrameLen  = 16 // ANMF frame header: position, size, duration and flags
+)
+
+// countFrames returns frames count of GIF or WebP image file, 1 for other images
+func countFrames(file string) (int, error) {
+	f, err := os.Open(file) // #nosec G304, file is created by saveFile
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(webpHeaderLen)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("GIF8")):
+		return gifFrames(r)
+	case len(magic) == webpHeaderLen && string(magic[:4]) == "RIFF" && string(magic[8:]) == "WEBP":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		var frames int
+		err = webpChunks(data, func(id string, _ []byte) bool {
+			if id == "ANMF" {
+				frames++
+			}
+			return true
+		})
+		if frames == 0 {
+			// still image
+			frames = 1
+		}
+		return frames, err
+	}
+	return 1, nil
+}
+
+// gifFrames counts GIF image descriptors without decoding frames
+func gifFrames(r *bufio.Reader) (frames int, err error) {
+	var header [gifHeaderLen + gifScreenLen]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	if err = skipColorTable(r, header[gifHeaderLen+4]); err != nil {
+		return
+	}
+	for {
+		var block byte
+		if block, err = r.ReadByte(); err != nil {
+			if errors.Is(err, io.EOF) {
+				// some encoders omit trailer, so end of file is end of stream
+				err = nil
+			}
+			return
+		}
+		switch block {
+		case gifExtension:
+			if _, err = r.ReadByte(); err != nil { // extension label
+				return
+			}
+		case gifImage:
+			frames++
+			var desc [gifImageLen]byte
+			if _, err = io.ReadFull(r, desc[:]); err != nil {
+				return
+			}
+			if err = skipColorTable(r, desc[gifImageLen-1]); err != nil {
+				return
+			}
+			if _, err = r.ReadByte(); err != nil { // LZW minimum code size
+				return
+			}
+		case gifTrailer:
+			return
+		default:
+			err = errors.New(ErrBadGIF)
+			return
+		}
+		if err = skipSubBlocks(r); err != nil {
+			return
+		}
+	}
+}
+
+// skipColorTable skips color table if it is present according to flags
+func skipColorTable(r *bufio.Reader, flags byte) error {
+	if flags&gifColorTable == 0 {
+		return nil
+	}
+	_, err := r.Discard(3 * (1 << (1 + flags&0x07)))
+	return err
+}
+
+// skipSubBlocks skips GIF data sub-blocks up to block terminator
+func skipSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err = r.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// webpChunks calls fn for every top level chunk of WebP data until fn returns false
+func webpChunks(data []byte, fn func(id string, payload []byte) bool) error {
+	if len(data) < webpHeaderLen {
+		return errors.New(ErrBadWebP)
+	}
+	data = data[webpHeaderLen:]
+	for len(data) >= webpChunkLen {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size < 0 || size > len(data)-webpChunkLen {
+			return errors.New(ErrBadWebP)
+		}
+		if !fn(id, data[webpChunkLen:webpChunkLen+size]) {
+			return nil
+		}
+		// chunks are padded to even size
+		size += size & 1
+		if size > len(data)-webpChunkLen {
+			break
+		}
+		data = data[webpChunkLen+size:]
+	}
+	return nil
+}
+
+// decodeWebPFrame decodes first frame of animated WebP file
+func decodeWebPFrame(file string) (image.Image, error) {
+	data, err := os.ReadFile(file) // #nosec G304, file is created by saveFile
+	if err != nil {
+		return nil, err
+	}
+	var frame []byte
+	if err = webpChunks(data, func(id string, payload []byte) bool {
+		if id == "ANMF" {
+			frame = payload
+			return false
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if len(frame) < webpFrameLen {
+		return nil, errors.New(ErrBadWebP)
+	}
+	// Wrap frame bitstream chunks into still image container
+	var buf bytes.Buffer
+	vp8x := make([]byte, webpChunkLen+10)
+	copy(vp8x, "VP8X")
+	binary.LittleEndian.PutUint32(vp8x[4:], 10)
+	if bytes.HasPrefix(frame[webpFrameLen:], []byte("ALPH")) {
+		vp8x[webpChunkLen] = 1 << 4 // alpha flag
+	}
+	copy(vp8x[webpChunkLen+4:], frame[6:12]) // frame width-1 and height-1
+	buf.WriteString("RIFF")
+	if err = binary.Write(&buf, binary.LittleEndian, uint32(4+len(vp8x)+len(frame)-webpFrameLen)); err != nil {
+		return nil, err
+	}
+	buf.WriteString("WEBP")
+	buf.Write(vp8x)
+	buf.Write(frame[webpFrameLen:])
+	return webp.Decode(&buf)
+}
+
+// writeAnimation saves resized copy of all animated GIF frames
+func writeAnimation(src, dst string, width, height int) (err error) {
+	var f *os.File
+	f, err = os.Open(src) // #nosec G304, file is created by saveFile
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var g *gif.GIF
+	if g, err = gif.DecodeAll(f); err != nil {
+		return
+	}
+	var fo *os.File
+	fo, err = os.Create(dst) // #nosec G304, checked via ReImageFileName.MatchString
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := fo.Close(); err == nil {
+			err = e
+		}
+	}()
+	err = gif.EncodeAll(fo, resizeGIF(g, width, height))
+	return
+}
+
+// resizeGIF returns animation with all frames resized, frame timing is kept
+func resizeGIF(g *gif.GIF, width, height int) *gif.GIF {
+	rv := &gif.GIF{Delay: g.Delay, LoopCount: g.LoopCount}
+	// frames may contain changed area only, so draw them on full size canvas
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var prev *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			prev = image.NewRGBA(canvas.Bounds())
+			draw.Draw(prev, prev.Bounds(), canvas, image.Point{}, draw.Src)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		small := imgconv.Resize(canvas, &imgconv.ResizeOption{Width: width, Height: height})
+		p := image.NewPaletted(small.Bounds(), frame.Palette)
+		draw.FloydSteinberg.Draw(p, p.Bounds(), small, small.Bounds().Min)
+		rv.Image = append(rv.Image, p)
+		rv.Disposal = append(rv.Disposal, gif.DisposalNone)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = prev
+		}
+	}
+	return rv
+}
@@ -0,0 +1,148 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (ss *ServerSuite) TestAnimationStatic() {
+	name, err := ss.srv.HandleBase64(helperAnimatedGIF(ss.T(), 3), "anim.gif", Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), meta.Animated)
+	assert.Equal(ss.T(), 3, meta.Frames)
+	g := helperLoadGIF(ss.T(), filepath.Join(ss.cfg.PreviewDir, *name))
+	assert.Equal(ss.T(), 1, len(g.Image))
+}
+
+func (ss *ServerSuite) TestAnimationAnimated() {
+	ss.srv.Config.Animation = AnimationAnimated // TODO: This is incompartible with parallel tests
+	defer func() { ss.srv.Config.Animation = AnimationStatic }()
+	name, err := ss.srv.HandleBase64(helperAnimatedGIF(ss.T(), 3), "anim.gif", Attrs{})
+	require.NoError(ss.T(), err)
+	g := helperLoadGIF(ss.T(), filepath.Join(ss.cfg.PreviewDir, *name))
+	require.Equal(ss.T(), 3, len(g.Image))
+	assert.Equal(ss.T(), []int{10, 20, 30}, g.Delay)
+	assert.Equal(ss.T(), image.Rect(0, 0, ss.cfg.PreviewWidth, ss.cfg.PreviewHeight), g.Image[0].Bounds())
+
+	// too many frames
+	ss.srv.Config.MaxFrames = 2
+	defer func() { ss.srv.Config.MaxFrames = ss.cfg.MaxFrames }()
+	name, err = ss.srv.HandleBase64(helperAnimatedGIF(ss.T(), 3), "anim.gif", Attrs{})
+	require.NoError(ss.T(), err)
+	g = helperLoadGIF(ss.T(), filepath.Join(ss.cfg.PreviewDir, *name))
+	assert.Equal(ss.T(), 1, len(g.Image))
+}
+
+func (ss *ServerSuite) TestAnimationWebP() {
+	data := helperAnimatedWebP(ss.T(), 2)
+	name, err := ss.srv.HandleBase64("data:image/webp;base64,"+base64.StdEncoding.EncodeToString(data), "anim.webp", Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), meta.Animated)
+	assert.Equal(ss.T(), 2, meta.Frames)
+	assert.Equal(ss.T(), 20, meta.Width)
+}
+
+func TestCountFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		frames int
+	}{
+		{"JPEG", "../testdata/pic.jpg", 1},
+		{"PNG", "../testdata/build.png", 1},
+	}
+	for _, tt := range tests {
+		frames, err := countFrames(tt.file)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.frames, frames, tt.name)
+	}
+	_, err := countFrames("../testdata/unknown.gif")
+	assert.NotNil(t, err)
+
+	file := filepath.Join(t.TempDir(), "bad.gif")
+	require.NoError(t, os.WriteFile(file, []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\xff"), 0600))
+	_, err = countFrames(file)
+	assert.EqualError(t, err, ErrBadGIF)
+
+	// missing trailer is end of stream
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(helperAnimatedGIF(t, 3), "data:image/gif;base64,"))
+	require.NoError(t, err)
+	file = filepath.Join(t.TempDir(), "notrailer.gif")
+	require.NoError(t, os.WriteFile(file, bytes.TrimSuffix(data, []byte{gifTrailer}), 0600))
+	frames, err := countFrames(file)
+	require.NoError(t, err)
+	assert.Equal(t, 3, frames)
+}
+
+// helperAnimatedGIF returns base64 data of animated GIF with frames count given
+func helperAnimatedGIF(t *testing.T, frames int) string {
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		img := image.NewPaletted(image.Rect(0, 0, 20, 10), palette.Plan9)
+		for x := 0; x < 20; x++ {
+			img.Set(x, i, color.White)
+		}
+		g.Image = append(g.Image, img)
+		g.Delay = append(g.Delay, (i+1)*10)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, g))
+	return "data:image/gif;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// helperLoadGIF decodes all frames of GIF file
+func helperLoadGIF(t *testing.T, file string) *gif.GIF {
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	require.NoError(t, err)
+	return g
+}
+
+// helperAnimatedWebP returns animated WebP with frames count given
+func helperAnimatedWebP(t *testing.T, frames int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	var still bytes.Buffer
+	require.NoError(t, nativewebp.Encode(&still, img, nil))
+	bitstream := still.Bytes()[webpHeaderLen:] // VP8L chunk
+
+	chunk := func(buf *bytes.Buffer, id string, data []byte) {
+		buf.WriteString(id)
+		require.NoError(t, binary.Write(buf, binary.LittleEndian, uint32(len(data))))
+		buf.Write(data)
+		if len(data)%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	chunk(&body, "VP8X", []byte{0x02, 0, 0, 0, 19, 0, 0, 9, 0, 0}) // animation flag and canvas size
+	chunk(&body, "ANIM", make([]byte, 6))
+	for i := 0; i < frames; i++ {
+		frame := append([]byte{0, 0, 0, 0, 0, 0, 19, 0, 0, 9, 0, 0, 100, 0, 0, 0}, bitstream...)
+		chunk(&body, "ANMF", frame)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(body.Len())))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
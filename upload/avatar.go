@@ -0,0 +1,85 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// ProfileDefault means image is stored as is
+	ProfileDefault = "default"
+	// ProfileAvatar means image is cropped to square and copies of avatar sizes are created
+	ProfileAvatar = "avatar"
+)
+
+// avatar returns image from src cropped to center square and masked by circle if configured.
+// Colors with ICC profile are converted to sRGB before cropping because encoded avatar has no profile.
+// Masked image is encoded as PNG, so fileName ext is changed in this case.
+func (srv Service) avatar(src io.Reader, contentType, fileName string) (io.Reader, string, error) {
+	ext := path.Ext(fileName)
+	if ext == "" {
+		var err error
+		if ext, err = contentTypeExt(contentType); err != nil {
+			return nil, "", err
+		}
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, "", err
+	}
+	notImage := NewHTTPError(http.StatusUnsupportedMediaType, errors.New(ErrNotImage))
+	img, err := imgconv.Decode(bytes.NewReader(data))
+	if err != nil {
+		srv.Log.Warnf("Avatar decode error: %v", err)
+		return nil, "", notImage
+	}
+	if profile := srv.iccOfData(ext, data); profile != nil && !profile.isSRGB() {
+		img = profile.convert(img)
+	}
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	square := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, b.Min.Add(image.Pt((b.Dx()-side)/2, (b.Dy()-side)/2)), draw.Src)
+	img = square
+
+	format, err := imgconv.FormatFromExtension(strings.TrimPrefix(ext, "."))
+	if srv.Config.AvatarCircle || err != nil {
+		format, ext = imgconv.PNG, RasterExt
+	}
+	if srv.Config.AvatarCircle {
+		img = circle(square)
+	}
+	var buf bytes.Buffer
+	if err = imgconv.Write(&buf, img, &imgconv.FormatOption{Format: format}); err != nil {
+		return nil, "", err
+	}
+	return &buf, strings.TrimSuffix(fileName, path.Ext(fileName)) + ext, nil
+}
+
+// circle returns copy of square image with transparent pixels outside of inscribed circle
+func circle(img *image.NRGBA) *image.NRGBA {
+	b := img.Bounds()
+	rv := image.NewNRGBA(b)
+	radius := float64(b.Dx()) / 2
+	mask := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			d := math.Hypot(float64(x-b.Min.X)+0.5-radius, float64(y-b.Min.Y)+0.5-radius)
+			// smooth edge of 1px width
+			a := math.Max(0, math.Min(1, radius-d+0.5))
+			mask.SetAlpha(x, y, color.Alpha{A: uint8(a * math.MaxUint8)})
+		}
+	}
+	draw.DrawMask(rv, b, img, b.Min, mask, b.Min, draw.Src)
+	return rv
+}
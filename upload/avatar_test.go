@@ -0,0 +1,69 @@
+package upload
+
+import (
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+func (ss *ServerSuite) TestAvatar() {
+	defer func() { ss.srv.Config.AvatarCircle = ss.cfg.AvatarCircle }()
+	avatar := Attrs{Profile: ProfileAvatar}
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, avatar)
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), ProfileAvatar, meta.Profile)
+	assert.Equal(ss.T(), meta.Width, meta.Height)
+	for _, size := range meta.SrcsetWidths {
+		if size != meta.Width {
+			assert.Contains(ss.T(), ss.cfg.AvatarSizes, size)
+		}
+		file, err := ss.srv.Variant(*name, size, 0)
+		require.NoError(ss.T(), err)
+		img, err := imgconv.Open(file)
+		require.NoError(ss.T(), err)
+		assert.Equal(ss.T(), image.Pt(size, size), img.Bounds().Size())
+	}
+
+	ss.srv.Config.AvatarCircle = true
+	name, err = ss.srv.HandleBase64(js.Data, "avatar.jpg", avatar)
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), strings.HasSuffix(*name, RasterExt))
+	img, err := imgconv.Open(filepath.Join(ss.cfg.Dir, *name))
+	require.NoError(ss.T(), err)
+	_, _, _, a := img.At(0, 0).RGBA()
+	assert.Equal(ss.T(), uint32(0), a, "corner is transparent")
+}
+
+func (ss *ServerSuite) TestAvatarICC() {
+	data := "data:image/png;base64," + base64.StdEncoding.EncodeToString(helperPNGWithICC(ss.T(), helperICC("Linear RGB")))
+	name, err := ss.srv.HandleBase64(data, "linear.png", Attrs{Profile: ProfileAvatar})
+	require.NoError(ss.T(), err)
+	img, err := imgconv.Open(filepath.Join(ss.cfg.Dir, *name))
+	require.NoError(ss.T(), err)
+	r, g, b, _ := img.At(4, 4).RGBA()
+	// linear 0.5 is 188 in sRGB, profile is lost after crop so it is applied before
+	for _, v := range []uint32{r >> 8, g >> 8, b >> 8} {
+		assert.InDelta(ss.T(), 188, v, 1)
+	}
+}
+
+func TestCircle(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	rv := circle(img)
+	assert.Equal(t, uint8(0), rv.NRGBAAt(0, 0).A)
+	assert.Equal(t, uint8(0xff), rv.NRGBAAt(5, 5).A)
+	assert.Equal(t, uint8(0xff), img.NRGBAAt(0, 0).A, "source is not changed")
+}
@@ -0,0 +1,131 @@
+package upload
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// ErrFmtInfected returned when antivirus found malware in uploaded file
+	ErrFmtInfected = "file is infected: %s"
+	// ErrScanFailed returned when antivirus scan is not completed and uploads fail closed
+	ErrScanFailed = "antivirus scan failed"
+	// ErrFmtClamd returned when clamd replies with error
+	ErrFmtClamd = "clamd error: %s"
+
+	// clamdChunkSize holds max size of INSTREAM chunk
+	clamdChunkSize = 32 * 1024
+	// clamdOK holds clamd reply for clean stream
+	clamdOK = "stream: OK"
+	// clamdFound holds clamd reply suffix for infected stream
+	clamdFound = " FOUND"
+)
+
+// clamdDial connects to clamd, addr is unix socket path (unix:/path or /path) or tcp host:port (tcp://host:port)
+func clamdDial(addr string, timeout time.Duration) (net.Conn, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.DialTimeout("unix", strings.TrimPrefix(path, "//"), timeout)
+	}
+	if strings.HasPrefix(addr, "/") {
+		return net.DialTimeout("unix", addr, timeout)
+	}
+	return net.DialTimeout("tcp", strings.TrimPrefix(addr, "tcp://"), timeout)
+}
+
+// clamdScan streams src to clamd with INSTREAM command and returns found virus name (empty if stream is clean).
+// err is set if scan is not completed.
+func clamdScan(addr string, timeout time.Duration, src io.Reader) (virus string, err error) {
+	conn, err := clamdDial(addr, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, e := io.ReadFull(src, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n)) // #nosec G115, n <= clamdChunkSize
+			if _, err = conn.Write(buf[:4+n]); err != nil {
+				return "", err
+			}
+		}
+		if errors.Is(e, io.EOF) || errors.Is(e, io.ErrUnexpectedEOF) {
+			break
+		}
+		if e != nil {
+			return "", e
+		}
+	}
+	// zero length chunk ends stream
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	if reply == clamdOK {
+		return "", nil
+	}
+	if found, ok := strings.CutSuffix(reply, clamdFound); ok {
+		return strings.TrimPrefix(found, "stream: "), nil
+	}
+	return "", fmt.Errorf(ErrFmtClamd, reply)
+}
+
+// scan saves src into temp file outside of public dirs and checks it with clamd.
+// Returned file is positioned at start and should be removed by caller.
+func (srv Service) scan(src io.Reader) (file *os.File, err error) {
+	cfg := srv.Config
+	file, err = os.CreateTemp("", "fiwes-scan-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			removeTemp(file)
+			file = nil
+		}
+	}()
+	if _, err = io.Copy(file, src); err != nil {
+		return
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	virus, err := clamdScan(cfg.ClamdAddr, cfg.ClamdTimeout, file)
+	if err != nil {
+		if !cfg.ClamdFailOpen {
+			srv.Log.Errorf("Scan error: %v", err)
+			return file, NewHTTPError(http.StatusServiceUnavailable, errors.New(ErrScanFailed))
+		}
+		srv.Log.Warnf("Scan error, file accepted unscanned: %v", err)
+	}
+	if virus != "" {
+		srv.Log.Warnf("Infected file rejected: %s", virus)
+		return file, NewHTTPError(http.StatusUnprocessableEntity, fmt.Errorf(ErrFmtInfected, virus))
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	return
+}
+
+// removeTemp closes and removes temp file
+func removeTemp(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
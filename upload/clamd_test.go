@@ -0,0 +1,122 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// helperClamd starts fake clamd which finds EICAR in streams, returns its address
+func helperClamd(t *testing.T, network, addr string) string {
+	ln, err := net.Listen(network, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil || cmd != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var data bytes.Buffer
+				for {
+					var size uint32
+					if binary.Read(r, binary.BigEndian, &size) != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err = io.CopyN(&data, r, int64(size)); err != nil {
+						return
+					}
+				}
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					return
+				}
+				conn.Write([]byte("stream: OK\x00"))
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamdScan(t *testing.T) {
+	addr := helperClamd(t, "tcp", "127.0.0.1:0")
+	virus, err := clamdScan(addr, time.Second, strings.NewReader(strings.Repeat("x", 3*clamdChunkSize+1)))
+	require.NoError(t, err)
+	assert.Equal(t, "", virus)
+
+	virus, err = clamdScan("tcp://"+addr, time.Second, strings.NewReader(strings.Repeat("x", clamdChunkSize)+"EICAR"))
+	require.NoError(t, err)
+	assert.Equal(t, "Eicar-Test-Signature", virus)
+
+	sock := helperClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"))
+	virus, err = clamdScan("unix://"+sock, time.Second, strings.NewReader("EICAR"))
+	require.NoError(t, err)
+	assert.Equal(t, "Eicar-Test-Signature", virus)
+	virus, err = clamdScan(sock, time.Second, strings.NewReader(""))
+	require.NoError(t, err)
+	assert.Equal(t, "", virus)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := ln.Addr().String()
+	ln.Close()
+	_, err = clamdScan(closed, time.Second, strings.NewReader("data"))
+	assert.Error(t, err)
+}
+
+func (ss *ServerSuite) TestScan() {
+	ss.srv.Config.ClamdAddr = helperClamd(ss.T(), "tcp", "127.0.0.1:0")
+	ss.srv.Config.ClamdTimeout = time.Second
+	defer func() {
+		ss.srv.Config.ClamdAddr = ss.cfg.ClamdAddr
+		ss.srv.Config.ClamdFailOpen = ss.cfg.ClamdFailOpen
+	}()
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	_, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{Owner: "scan"})
+	require.NoError(ss.T(), err)
+
+	virus := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR"))
+	_, err = ss.srv.HandleBase64(virus, "virus.png", Attrs{Owner: "scan"})
+	require.Error(ss.T(), err)
+	assert.Equal(ss.T(), http.StatusUnprocessableEntity, err.(*HTTPError).Status())
+	_, e := os.Stat(filepath.Join(ss.cfg.Dir, "scan", "virus.png"))
+	assert.True(ss.T(), os.IsNotExist(e), "infected file is not saved")
+
+	// clamd is not available
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(ss.T(), err)
+	ss.srv.Config.ClamdAddr = ln.Addr().String()
+	ln.Close()
+	_, err = ss.srv.HandleBase64(js.Data, js.Name, Attrs{Owner: "scan"})
+	require.Error(ss.T(), err)
+	assert.Equal(ss.T(), http.StatusServiceUnavailable, err.(*HTTPError).Status())
+	assert.EqualError(ss.T(), err, ErrScanFailed)
+
+	ss.srv.Config.ClamdFailOpen = true
+	_, err = ss.srv.HandleBase64(js.Data, js.Name, Attrs{Owner: "scan"})
+	assert.NoError(ss.T(), err)
+}
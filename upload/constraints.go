@@ -0,0 +1,131 @@
+package upload
+
+import (
+	"errors"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	// ErrConstraintSize returned when image exceeds size allowed by presigned URL
+	ErrConstraintSize = "image exceeds presigned size limit"
+	// ErrConstraintFormat returned when image format is not allowed by presigned URL
+	ErrConstraintFormat = "image format is not allowed by presigned URL"
+)
+
+// Constraints holds limits of presigned upload
+type Constraints struct {
+	MaxSize int64    // bytes, 0 - unlimited
+	Formats []string // allowed file extensions, empty - any
+	Name    string   // target file name, empty - from request
+}
+
+// normalizeFormat returns lowercase file extension without dot and aliases
+func normalizeFormat(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	switch ext {
+	case "jpeg":
+		return "jpg"
+	case "tif":
+		return "tiff"
+	}
+	return ext
+}
+
+// check validates upload against constraints and returns file name to save image with.
+// size is expected image size, 0 if unknown.
+func (c Constraints) check(contentType, fileName string, size int64) (string, error) {
+	if c.Name != "" {
+		if !ReImageFileName.MatchString(c.Name) {
+			return "", NewHTTPError(http.StatusBadRequest, errors.New(ErrBadFilename))
+		}
+		fileName = c.Name
+	}
+	if c.MaxSize > 0 && size > c.MaxSize {
+		return "", NewHTTPError(http.StatusRequestEntityTooLarge, errors.New(ErrConstraintSize))
+	}
+	if len(c.Formats) == 0 {
+		return fileName, nil
+	}
+	ext := path.Ext(fileName)
+	if ext == "" {
+		var err error
+		if ext, err = contentTypeExt(contentType); err != nil {
+			return "", err
+		}
+	}
+	if err := c.checkFormat(ext); err != nil {
+		return "", err
+	}
+	return fileName, nil
+}
+
+// checkFormat validates image format (file extension or decoder format name) against constraints
+func (c Constraints) checkFormat(format string) error {
+	if len(c.Formats) == 0 {
+		return nil
+	}
+	format = normalizeFormat(format)
+	if format == "" || !slices.ContainsFunc(c.Formats, func(f string) bool { return normalizeFormat(f) == format }) {
+		return NewHTTPError(http.StatusUnsupportedMediaType, errors.New(ErrConstraintFormat))
+	}
+	return nil
+}
+
+// imageFormat returns format name of stored image decoded by openImage, empty if format is unknown
+func (srv Service) imageFormat(file string) string {
+	switch {
+	case srv.Config.SVG && isSVG(file):
+		return "svg"
+	case isICO(file):
+		// ICO is decoded by own parser
+		return "ico"
+	}
+	f, err := os.Open(file) // #nosec G304, file is stored image
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	_, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return ""
+	}
+	return format
+}
+
+// sizeReader fails reading with err after max bytes
+type sizeReader struct {
+	r        io.Reader
+	max, cnt int64
+	err      error
+}
+
+// Read implements io.Reader
+func (r *sizeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.cnt += int64(n)
+	if r.exceeded() {
+		return n, r.err
+	}
+	return n, err
+}
+
+// exceeded checks if source is larger than max
+func (r *sizeReader) exceeded() bool {
+	return r.cnt > r.max
+}
+
+// sizeError returns error of first exceeded size limit, nil if none
+func sizeError(limits []*sizeReader) error {
+	for _, r := range limits {
+		if r.exceeded() {
+			return r.err
+		}
+	}
+	return nil
+}
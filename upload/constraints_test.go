@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstraintsCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		c     Constraints
+		cType string
+		file  string
+		size  int64
+		want  string
+		err   string
+	}{
+		{"Any", Constraints{}, "", "cat.png", 100, "cat.png", ""},
+		{"Name", Constraints{Name: "dog.jpg"}, "", "cat.png", 100, "dog.jpg", ""},
+		{"BadName", Constraints{Name: "../dog.jpg"}, "", "cat.png", 100, "", ErrBadFilename},
+		{"Size", Constraints{MaxSize: 10}, "", "cat.png", 100, "", ErrConstraintSize},
+		{"SizeUnknown", Constraints{MaxSize: 10}, "", "cat.png", 0, "cat.png", ""},
+		{"Format", Constraints{Formats: []string{"JPG"}}, "", "cat.jpeg", 100, "cat.jpeg", ""},
+		{"FormatCType", Constraints{Formats: []string{"png"}}, "image/png", "cat", 100, "cat", ""},
+		{"FormatDenied", Constraints{Formats: []string{"png"}}, "", "cat.gif", 100, "", ErrConstraintFormat},
+		{"NameFormat", Constraints{Name: "dog.gif", Formats: []string{"png"}}, "", "cat.png", 100, "", ErrConstraintFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.c.check(tt.cType, tt.file, tt.size)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func (ss *ServerSuite) TestConstraints() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	attrs := Attrs{Owner: "presign", Constraints: &Constraints{Name: "fixed.png", Formats: []string{"png"}}}
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, attrs)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), "/presign/fixed.png", *name)
+
+	// name is fixed, second upload goes to random dir
+	name, err = ss.srv.HandleBase64(js.Data, js.Name, attrs)
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), strings.HasSuffix(*name, "/fixed.png"), *name)
+	assert.NotEqual(ss.T(), "/presign/fixed.png", *name)
+
+	// decoded format is checked, not name only
+	attrs.Constraints = &Constraints{Name: "fake.jpg", Formats: []string{"jpg"}}
+	_, err = ss.srv.HandleBase64(js.Data, js.Name, attrs)
+	assert.EqualError(ss.T(), err, ErrConstraintFormat)
+	_, err = os.Stat(filepath.Join(ss.cfg.Dir, "presign", "fake.jpg"))
+	assert.True(ss.T(), os.IsNotExist(err))
+
+	attrs.Constraints = &Constraints{MaxSize: 100}
+	_, err = ss.srv.HandleBase64(js.Data, js.Name, attrs)
+	assert.EqualError(ss.T(), err, ErrConstraintSize)
+
+	// size is checked while reading if unknown
+	meta := attrs.meta()
+	_, err = ss.srv.saveFile(strings.NewReader(strings.Repeat("x", 200)), "image/png", "big.png", meta)
+	assert.EqualError(ss.T(), err, ErrConstraintSize)
+	usage, err := ss.srv.Usage(attrs)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 2, usage.ByOwner.Files)
+}
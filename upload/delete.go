@@ -0,0 +1,39 @@
+package upload
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Delete removes stored image with its metadata, preview, variants and tiles.
+// Only owner or admin can delete image.
+func (srv Service) Delete(name string, attrs Attrs) error {
+	cfg := srv.Config
+	meta, err := srv.OwnedMeta(name, attrs)
+	if err != nil {
+		return err
+	}
+	name = path.Clean("/" + meta.Name)
+	// metadata is removed first, so image is not listed anymore even if cleanup fails
+	files := []string{
+		srv.metaFile(name),
+		filepath.Join(cfg.Dir, name),
+		filepath.Join(cfg.PreviewDir, RasterName(name)),
+		filepath.Join(cfg.TileDir, name+TileDescriptorExt),
+		filepath.Join(cfg.TileDir, name+TileFilesSuffix),
+	}
+	variants, err := filepath.Glob(filepath.Join(cfg.VariantDir, "*", RasterName(name)))
+	if err != nil {
+		return err
+	}
+	for _, file := range append(files, variants...) {
+		if err = os.RemoveAll(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	srv.Log.Infof("Deleted %s", name)
+	return srv.releaseQuota(meta)
+}
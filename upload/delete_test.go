@@ -0,0 +1,34 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (ss *ServerSuite) TestDelete() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{KeyID: "ci"})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), "ci", meta.KeyID)
+	variant, err := ss.srv.Variant(*name, 10, 0)
+	require.NoError(ss.T(), err)
+
+	require.NoError(ss.T(), ss.srv.Delete(*name, Attrs{}))
+	for _, file := range []string{
+		filepath.Join(ss.cfg.Dir, *name),
+		filepath.Join(ss.cfg.PreviewDir, *name),
+		ss.srv.metaFile(*name),
+		variant,
+	} {
+		_, err = os.Stat(file)
+		assert.True(ss.T(), os.IsNotExist(err), file)
+	}
+	err = ss.srv.Delete(*name, Attrs{})
+	assert.EqualError(ss.T(), err, ErrNotFound)
+}
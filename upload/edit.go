@@ -0,0 +1,163 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// ErrBadOperation returned when edit operation is unknown or has wrong arguments
+	ErrBadOperation = "unsupported edit operation"
+	// ErrTooManyOperations returned when edit operation list is empty or too long
+	ErrTooManyOperations = "edit operations count must be in range 1..%d"
+
+	// MaxEditOperations holds max operations count per edit request
+	MaxEditOperations = 20
+
+	// OpRotate rotates image clockwise by Angle (90, 180 or 270)
+	OpRotate = "rotate"
+	// OpFlip flips image in Direction (horizontal or vertical)
+	OpFlip = "flip"
+	// OpCrop crops image to rectangle X, Y, Width, Height
+	OpCrop = "crop"
+	// OpGrayscale converts image to grayscale
+	OpGrayscale = "grayscale"
+)
+
+// Operation holds image edit operation
+type Operation struct {
+	Op        string `json:"op"`
+	Angle     int    `json:"angle,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	X         int    `json:"x,omitempty"`
+	Y         int    `json:"y,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+// Edit applies operations to stored image and saves result as new image version.
+// Edited image is stored as new file, so source version is kept.
+// Only owner or admin can edit image, new version keeps owner of source.
+func (srv Service) Edit(name string, ops []Operation, attrs Attrs) (*string, error) {
+	if len(ops) == 0 || len(ops) > MaxEditOperations {
+		return nil, NewHTTPError(http.StatusBadRequest, fmt.Errorf(ErrTooManyOperations, MaxEditOperations))
+	}
+	src, err := srv.OwnedMeta(name, attrs)
+	if err != nil {
+		return nil, err
+	}
+	img, err := srv.openOriginal(src.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if img, err = op.apply(img); err != nil {
+			return nil, NewHTTPError(http.StatusBadRequest, err)
+		}
+	}
+	ext := path.Ext(src.Name)
+	format, err := imgconv.FormatFromExtension(strings.TrimPrefix(ext, "."))
+	if err != nil {
+		// vector images can not be edited
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, errors.New(ErrNotImage))
+	}
+	var buf bytes.Buffer
+	if err = imgconv.Write(&buf, img, &imgconv.FormatOption{Format: format}); err != nil {
+		return nil, err
+	}
+
+	meta := attrs.meta()
+	meta.Owner, meta.Original, meta.Version = src.Owner, src.Original, src.Version+1
+	meta.Private = meta.Private || src.Private
+	meta.Size = int64(buf.Len())
+	if meta.Original == "" {
+		// source is the first version
+		meta.Original = src.Name
+		meta.Version = 2
+	}
+	base := strings.TrimSuffix(path.Base(meta.Original), ext)
+	fileName := fmt.Sprintf("%s-v%d%s", base, meta.Version, ext)
+	newName, err := srv.saveFile(&buf, "", fileName, meta)
+	if err != nil {
+		return nil, err
+	}
+	return &newName, nil
+}
+
+// apply returns image changed by operation
+func (op Operation) apply(img image.Image) (image.Image, error) {
+	switch op.Op {
+	case OpRotate:
+		switch op.Angle {
+		case 90, 180, 270:
+			return rotate(img, op.Angle), nil
+		}
+	case OpFlip:
+		switch op.Direction {
+		case "horizontal", "vertical":
+			return flip(img, op.Direction == "horizontal"), nil
+		}
+	case OpCrop:
+		rect := image.Rect(op.X, op.Y, op.X+op.Width, op.Y+op.Height).Add(img.Bounds().Min)
+		if op.Width > 0 && op.Height > 0 && rect.In(img.Bounds()) {
+			rv := image.NewNRGBA(image.Rect(0, 0, op.Width, op.Height))
+			draw.Draw(rv, rv.Bounds(), img, rect.Min, draw.Src)
+			return rv, nil
+		}
+	case OpGrayscale:
+		return imgconv.ToGray(img), nil
+	}
+	return nil, errors.New(ErrBadOperation)
+}
+
+// rotate returns image rotated clockwise by 90, 180 or 270 degrees
+func rotate(img image.Image, angle int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var rv *image.NRGBA
+	if angle == 180 {
+		rv = image.NewNRGBA(image.Rect(0, 0, w, h))
+	} else {
+		rv = image.NewNRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			switch angle {
+			case 90:
+				rv.Set(h-1-y, x, c)
+			case 180:
+				rv.Set(w-1-x, h-1-y, c)
+			case 270:
+				rv.Set(y, w-1-x, c)
+			}
+		}
+	}
+	return rv
+}
+
+// flip returns image mirrored horizontally or vertically
+func flip(img image.Image, horizontal bool) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	rv := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			if horizontal {
+				rv.Set(w-1-x, y, c)
+			} else {
+				rv.Set(x, h-1-y, c)
+			}
+		}
+	}
+	return rv
+}
@@ -0,0 +1,91 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (ss *ServerSuite) TestEdit() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	src, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+
+	v2, err := ss.srv.Edit(*name, []Operation{{Op: OpRotate, Angle: 90}, {Op: OpGrayscale}}, Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*v2)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), src.Height, meta.Width)
+	assert.Equal(ss.T(), src.Width, meta.Height)
+	assert.Equal(ss.T(), *name, meta.Original)
+	assert.Equal(ss.T(), 2, meta.Version)
+
+	v3, err := ss.srv.Edit(*v2, []Operation{{Op: OpCrop, X: 1, Y: 2, Width: 10, Height: 5}, {Op: OpFlip, Direction: "vertical"}}, Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err = ss.srv.Meta(*v3)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 10, meta.Width)
+	assert.Equal(ss.T(), 5, meta.Height)
+	assert.Equal(ss.T(), *name, meta.Original)
+	assert.Equal(ss.T(), 3, meta.Version)
+
+	// source version is kept
+	_, err = ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+
+	tests := []struct {
+		name   string
+		file   string
+		ops    []Operation
+		status int
+	}{
+		{"NoOps", *name, []Operation{}, http.StatusBadRequest},
+		{"UnknownOp", *name, []Operation{{Op: "blur"}}, http.StatusBadRequest},
+		{"BadAngle", *name, []Operation{{Op: OpRotate, Angle: 45}}, http.StatusBadRequest},
+		{"BadDirection", *name, []Operation{{Op: OpFlip, Direction: "up"}}, http.StatusBadRequest},
+		{"BadCrop", *name, []Operation{{Op: OpCrop, Width: src.Width + 1, Height: 1}}, http.StatusBadRequest},
+		{"NotFound", "/unknown.png", []Operation{{Op: OpGrayscale}}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		_, err := ss.srv.Edit(tt.file, tt.ops, Attrs{})
+		require.NotNil(ss.T(), err, tt.name)
+		httpErr, ok := err.(interface{ Status() int })
+		assert.True(ss.T(), ok, tt.name)
+		assert.Equal(ss.T(), tt.status, httpErr.Status(), tt.name)
+	}
+}
+
+func TestRotateFlip(t *testing.T) {
+	// 2x1 image: red, blue
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		size   image.Point
+		first  color.Color
+		second image.Point
+	}{
+		{"Rotate90", rotate(img, 90), image.Pt(1, 2), red, image.Pt(0, 1)},
+		{"Rotate180", rotate(img, 180), image.Pt(2, 1), blue, image.Pt(1, 0)},
+		{"Rotate270", rotate(img, 270), image.Pt(1, 2), blue, image.Pt(0, 1)},
+		{"FlipH", flip(img, true), image.Pt(2, 1), blue, image.Pt(1, 0)},
+		{"FlipV", flip(img, false), image.Pt(2, 1), red, image.Pt(1, 0)},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.size, tt.img.Bounds().Size(), tt.name)
+		assert.Equal(t, tt.first, tt.img.At(0, 0), tt.name)
+		assert.NotEqual(t, tt.first, tt.img.At(tt.second.X, tt.second.Y), tt.name)
+	}
+}
@@ -0,0 +1,52 @@
+package upload
+
+import (
+	"image/png"
+
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// PresetPreview holds encoding preset name of previews
+	PresetPreview = "preview"
+	// PresetVariant holds encoding preset name of image variants (srcset, on-demand and IIIF)
+	PresetVariant = "variant"
+	// PresetTile holds encoding preset name of deep zoom tiles
+	PresetTile = "tile"
+)
+
+// pngCompression holds PNG compression levels by config value
+var pngCompression = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// encodeOptions returns image encoding options of preset.
+// Zero quality and empty compression mean imgconv defaults.
+func (srv Service) encodeOptions(preset string) []imgconv.EncodeOption {
+	cfg := srv.Config
+	var quality int
+	var compression string
+	switch preset {
+	case PresetPreview:
+		quality, compression = cfg.PreviewQuality, cfg.PreviewCompression
+	case PresetVariant:
+		quality, compression = cfg.VariantQuality, cfg.VariantCompression
+	case PresetTile:
+		quality, compression = cfg.TileQuality, cfg.TileCompression
+	}
+	return encodeOptions(quality, compression)
+}
+
+// encodeOptions returns JPEG quality and PNG compression level options
+func encodeOptions(quality int, compression string) (rv []imgconv.EncodeOption) {
+	if quality > 0 {
+		rv = append(rv, imgconv.Quality(min(quality, 100)))
+	}
+	if level, ok := pngCompression[compression]; ok {
+		rv = append(rv, imgconv.PNGCompressionLevel(level))
+	}
+	return
+}
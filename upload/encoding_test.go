@@ -0,0 +1,72 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+// encodeSize returns size of img encoded with given options
+func encodeSize(tb testing.TB, img image.Image, format imgconv.Format, opts []imgconv.EncodeOption) int {
+	var buf bytes.Buffer
+	require.NoError(tb, imgconv.Write(&buf, img, &imgconv.FormatOption{Format: format, EncodeOption: opts}))
+	return buf.Len()
+}
+
+func TestEncodeOptions(t *testing.T) {
+	img, err := imgconv.Open(filepath.Join("../testdata", "pic100.jpg"))
+	require.NoError(t, err)
+	assert.Less(t, encodeSize(t, img, imgconv.JPEG, encodeOptions(30, "")),
+		encodeSize(t, img, imgconv.JPEG, encodeOptions(95, "")))
+	assert.Less(t, encodeSize(t, img, imgconv.PNG, encodeOptions(0, "best")),
+		encodeSize(t, img, imgconv.PNG, encodeOptions(0, "none")))
+	assert.Empty(t, encodeOptions(0, ""))
+	assert.Len(t, encodeOptions(101, "unknown"), 1)
+
+	srv := Service{Config: &Config{PreviewQuality: 50, VariantCompression: "best", TileQuality: 90, TileCompression: "speed"}}
+	assert.Len(t, srv.encodeOptions(PresetPreview), 1)
+	assert.Len(t, srv.encodeOptions(PresetVariant), 1)
+	assert.Len(t, srv.encodeOptions(PresetTile), 2)
+	assert.Empty(t, srv.encodeOptions("unknown"))
+}
+
+// BenchmarkEncoding reports output size of testdata images for each encoding setting.
+// Run with: go test -run '^$' -bench Encoding ./upload
+func BenchmarkEncoding(b *testing.B) {
+	settings := []struct {
+		name    string
+		format  imgconv.Format
+		quality int
+		png     string
+	}{
+		{"jpeg-q50", imgconv.JPEG, 50, ""},
+		{"jpeg-q75", imgconv.JPEG, 75, ""},
+		{"jpeg-q90", imgconv.JPEG, 90, ""},
+		{"jpeg-q100", imgconv.JPEG, 100, ""},
+		{"png-none", imgconv.PNG, 0, "none"},
+		{"png-speed", imgconv.PNG, 0, "speed"},
+		{"png-default", imgconv.PNG, 0, "default"},
+		{"png-best", imgconv.PNG, 0, "best"},
+		{"webp-lossless", imgconv.WEBP, 0, ""},
+	}
+	for _, file := range []string{"pic.jpg", "pic100.jpg", "build.png", "build100.png"} {
+		img, err := imgconv.Open(filepath.Join("../testdata", file))
+		require.NoError(b, err)
+		for _, tt := range settings {
+			b.Run(fmt.Sprintf("%s/%s", file, tt.name), func(b *testing.B) {
+				opts := encodeOptions(tt.quality, tt.png)
+				var size int
+				for i := 0; i < b.N; i++ {
+					size = encodeSize(b, img, tt.format, opts)
+				}
+				b.ReportMetric(float64(size), "bytes")
+			})
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package upload
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// ColorProfileSRGB means images with embedded ICC profile are converted to sRGB
+	ColorProfileSRGB = "srgb"
+	// ColorProfileIgnore means embedded ICC profiles are ignored
+	ColorProfileIgnore = "ignore"
+
+	// ICCOriginalKeep means original is stored as is, with its ICC profile
+	ICCOriginalKeep = "keep"
+	// ICCOriginalConvert means original is converted to sRGB and stored without ICC profile
+	ICCOriginalConvert = "convert"
+
+	// ErrICCUnsupported returned when ICC profile is not RGB matrix/TRC profile
+	ErrICCUnsupported = "unsupported icc profile"
+	// ErrICCTooLarge returned when ICC profile exceeds iccMaxSize
+	ErrICCTooLarge = "icc profile is too large"
+
+	// iccMaxSize holds max size of decompressed ICC profile
+	iccMaxSize   = 4 << 20
+	iccHeaderLen = 128
+	iccTagLen    = 12
+	// iccLinearSteps holds size of linear to sRGB lookup table
+	iccLinearSteps = 4096
+)
+
+// xyzD50ToSRGB holds matrix of PCS (XYZ, D50) to linear sRGB conversion (Bradford adapted)
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// iccProfile holds RGB matrix/TRC ICC profile
+type iccProfile struct {
+	desc  string
+	toXYZ [3][3]float64            // columns are red, green and blue colorants
+	trc   [3]func(float64) float64 // tone reproduction curves
+}
+
+// readICC returns ICC profile embedded in JPEG, PNG or WebP file, nil if file has no profile
+func readICC(file string) ([]byte, error) {
+	if iccExtractor(file) == nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file) // #nosec G304, file is stored image
+	if err != nil {
+		return nil, err
+	}
+	return extractICC(file, data)
+}
+
+// extractICC returns ICC profile embedded in data of image named name, nil if image has no profile
+func extractICC(name string, data []byte) ([]byte, error) {
+	extract := iccExtractor(name)
+	if extract == nil {
+		return nil, nil
+	}
+	return extract(data)
+}
+
+// iccExtractor returns ICC profile extractor for image format of name ext, nil if format is not supported
+func iccExtractor(name string) func([]byte) ([]byte, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return jpegICC
+	case ".png":
+		return pngICC
+	case ".webp":
+		return webpICC
+	}
+	return nil
+}
+
+// jpegICC returns ICC profile assembled from JPEG APP2 segments
+func jpegICC(data []byte) ([]byte, error) {
+	const marker = "ICC_PROFILE\x00"
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil, image.ErrFormat
+	}
+	chunks := map[byte][]byte{}
+	var count byte
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xff; {
+		kind, size := data[pos+1], int(binary.BigEndian.Uint16(data[pos+2:]))
+		if kind == 0xda || pos+2+size > len(data) || size < 2 {
+			break // image data started
+		}
+		segment := data[pos+4 : pos+2+size]
+		if kind == 0xe2 && len(segment) > len(marker)+2 && string(segment[:len(marker)]) == marker {
+			chunks[segment[len(marker)]] = segment[len(marker)+2:]
+			count = segment[len(marker)+1]
+		}
+		pos += 2 + size
+	}
+	var rv []byte
+	for i := byte(1); i <= count; i++ {
+		chunk, ok := chunks[i]
+		if !ok {
+			return nil, errors.New(ErrICCUnsupported)
+		}
+		rv = append(rv, chunk...)
+	}
+	return rv, nil
+}
+
+// pngICC returns ICC profile from PNG iCCP chunk
+func pngICC(data []byte) ([]byte, error) {
+	const signatureLen = 8
+	if len(data) < signatureLen {
+		return nil, image.ErrFormat
+	}
+	for pos := signatureLen; pos+8 <= len(data); {
+		size, kind := int(binary.BigEndian.Uint32(data[pos:])), string(data[pos+4:pos+8])
+		if size < 0 || pos+12+size > len(data) || kind == "IDAT" {
+			break
+		}
+		if kind == "iCCP" {
+			// profile name, zero separator and compression method precede profile
+			_, compressed, ok := bytes.Cut(data[pos+8:pos+8+size], []byte{0})
+			if !ok || len(compressed) < 1 {
+				return nil, image.ErrFormat
+			}
+			r, err := zlib.NewReader(bytes.NewReader(compressed[1:]))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			// compressed profile may expand to any size
+			profile, err := io.ReadAll(io.LimitReader(r, iccMaxSize+1))
+			if err != nil {
+				return nil, err
+			}
+			if len(profile) > iccMaxSize {
+				return nil, errors.New(ErrICCTooLarge)
+			}
+			return profile, nil
+		}
+		pos += 12 + size
+	}
+	return nil, nil
+}
+
+// webpICC returns ICC profile from WebP ICCP chunk
+func webpICC(data []byte) (rv []byte, err error) {
+	err = webpChunks(data, func(id string, payload []byte) bool {
+		if id == "ICCP" {
+			rv = payload
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// parseICC parses RGB matrix/TRC ICC profile
+func parseICC(data []byte) (*iccProfile, error) {
+	unsupported := errors.New(ErrICCUnsupported)
+	if len(data) < iccHeaderLen+4 || string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, unsupported
+	}
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[iccHeaderLen:]))
+	for i := 0; i < count; i++ {
+		pos := iccHeaderLen + 4 + i*iccTagLen
+		if pos+iccTagLen > len(data) {
+			return nil, unsupported
+		}
+		offset, size := binary.BigEndian.Uint32(data[pos+4:]), binary.BigEndian.Uint32(data[pos+8:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, unsupported
+		}
+		tags[string(data[pos:pos+4])] = data[offset : offset+size]
+	}
+	rv := &iccProfile{desc: iccText(tags["desc"])}
+	for i, prefix := range []string{"r", "g", "b"} {
+		xyz := tags[prefix+"XYZ"]
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, unsupported
+		}
+		for j := 0; j < 3; j++ {
+			rv.toXYZ[j][i] = s15Fixed16(xyz[8+j*4:])
+		}
+		curve, err := iccCurve(tags[prefix+"TRC"])
+		if err != nil {
+			return nil, err
+		}
+		rv.trc[i] = curve
+	}
+	return rv, nil
+}
+
+// iccCurve parses ICC curv or para tag
+func iccCurve(data []byte) (func(float64) float64, error) {
+	unsupported := errors.New(ErrICCUnsupported)
+	if len(data) < 12 {
+		return nil, unsupported
+	}
+	switch string(data[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(data[8:]))
+		if len(data) < 12+count*2 {
+			return nil, unsupported
+		}
+		switch count {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(data[12:])) / 256
+			if gamma == 0 {
+				return nil, unsupported
+			}
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(data[12+i*2:])) / math.MaxUint16
+		}
+		return func(x float64) float64 {
+			pos := x * float64(count-1)
+			i := min(int(pos), count-2)
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+	case "para":
+		kind := binary.BigEndian.Uint16(data[8:])
+		counts := []int{1, 3, 4, 5, 7}
+		if int(kind) >= len(counts) || len(data) < 12+counts[kind]*4 {
+			return nil, unsupported
+		}
+		p := make([]float64, 7)
+		for i := 0; i < counts[kind]; i++ {
+			p[i] = s15Fixed16(data[12+i*4:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		if !(g > 0) || math.IsInf(g, 0) {
+			// negative gamma gives infinite or NaN values
+			return nil, unsupported
+		}
+		switch kind {
+		case 0:
+			a, d = 1, math.Inf(-1)
+		case 1, 2:
+			// values below -b/a are constant
+			d = -b / a
+			if kind == 2 {
+				e, f = c, c
+			}
+			c = 0
+		}
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(math.Max(a*x+b, 0), g) + e
+			}
+			return c*x + f
+		}, nil
+	}
+	return nil, unsupported
+}
+
+// iccText returns text of ICC desc (v2) or mluc (v4) tag
+func iccText(data []byte) string {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "desc":
+		size := int(binary.BigEndian.Uint32(data[8:]))
+		if len(data) >= 12+size {
+			return strings.TrimRight(string(data[12:12+size]), "\x00")
+		}
+	case len(data) >= 28 && string(data[:4]) == "mluc":
+		size, offset := int(binary.BigEndian.Uint32(data[20:])), int(binary.BigEndian.Uint32(data[24:]))
+		if size%2 == 0 && offset+size <= len(data) {
+			units := make([]uint16, size/2)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(data[offset+i*2:])
+			}
+			return string(utf16.Decode(units))
+		}
+	}
+	return ""
+}
+
+// s15Fixed16 decodes ICC signed fixed point number
+func s15Fixed16(data []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(data))) / 0x10000
+}
+
+// isSRGB checks if profile describes sRGB color space, so conversion is not needed
+func (p *iccProfile) isSRGB() bool {
+	return strings.Contains(strings.ToLower(p.desc), "srgb")
+}
+
+// convert returns copy of image with colors converted from profile to sRGB
+func (p *iccProfile) convert(img image.Image) image.Image {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += xyzD50ToSRGB[i][k] * p.toXYZ[k][j]
+			}
+		}
+	}
+	var linear [3][256]float64
+	for ch := 0; ch < 3; ch++ {
+		for v := 0; v < 256; v++ {
+			linear[ch][v] = p.trc[ch](float64(v) / math.MaxUint8)
+		}
+	}
+	var encode [iccLinearSteps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(srgbEncode(float64(i)/iccLinearSteps) * math.MaxUint8))
+	}
+
+	b := img.Bounds()
+	rv := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			src := [3]float64{linear[0][c.R], linear[1][c.G], linear[2][c.B]}
+			var dst [3]uint8
+			for i := 0; i < 3; i++ {
+				v := m[i][0]*src[0] + m[i][1]*src[1] + m[i][2]*src[2]
+				if math.IsNaN(v) {
+					// broken profile curves must not break lookup
+					v = 0
+				}
+				dst[i] = encode[int(math.Round(math.Max(0, math.Min(1, v))*iccLinearSteps))]
+			}
+			rv.SetNRGBA(x, y, color.NRGBA{R: dst[0], G: dst[1], B: dst[2], A: c.A})
+		}
+	}
+	return rv
+}
+
+// srgbEncode applies sRGB transfer function to linear value
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// iccOf returns convertible ICC profile of image file, nil if file has no such profile
+// or conversion is disabled
+func (srv Service) iccOf(file string) *iccProfile {
+	return srv.convertibleICC(file, func() ([]byte, error) { return readICC(file) })
+}
+
+// iccOfData returns convertible ICC profile of image data named name, nil if image has no such profile
+// or conversion is disabled
+func (srv Service) iccOfData(name string, data []byte) *iccProfile {
+	return srv.convertibleICC(name, func() ([]byte, error) { return extractICC(name, data) })
+}
+
+// convertibleICC parses ICC profile of image name returned by read if conversion is enabled
+func (srv Service) convertibleICC(name string, read func() ([]byte, error)) *iccProfile {
+	if srv.Config.ColorProfile != ColorProfileSRGB {
+		return nil
+	}
+	data, err := read()
+	if err == nil && len(data) == 0 {
+		return nil
+	}
+	var profile *iccProfile
+	if err == nil {
+		profile, err = parseICC(data)
+	}
+	if err != nil {
+		srv.Log.Warnf("ICC profile of %s ignored: %v", name, err)
+		return nil
+	}
+	return profile
+}
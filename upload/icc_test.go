@@ -0,0 +1,239 @@
+package upload
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+// helperICC returns ICC profile with sRGB primaries and linear tone curves
+func helperICC(desc string) []byte {
+	be := binary.BigEndian
+	xyz := func(x, y, z float64) []byte {
+		rv := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range []float64{x, y, z} {
+			rv = be.AppendUint32(rv, uint32(int32(v*0x10000)))
+		}
+		return rv
+	}
+	text := be.AppendUint32([]byte("desc\x00\x00\x00\x00"), uint32(len(desc)+1))
+	text = append(text, desc+"\x00"...)
+	curve := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", text},
+		{"rXYZ", xyz(0.4360747, 0.2225045, 0.0139322)},
+		{"gXYZ", xyz(0.3850649, 0.7168786, 0.0971045)},
+		{"bXYZ", xyz(0.1430804, 0.0606169, 0.7141733)},
+		{"rTRC", curve}, {"gTRC", curve}, {"bTRC", curve},
+	}
+	header := make([]byte, iccHeaderLen)
+	copy(header[16:], "RGB XYZ ")
+	table := be.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := len(header) + 4 + len(tags)*iccTagLen
+	for _, tag := range tags {
+		table = append(table, tag.sig...)
+		table = be.AppendUint32(table, uint32(offset+len(data)))
+		table = be.AppendUint32(table, uint32(len(tag.data)))
+		data = append(data, tag.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	rv := append(append(header, table...), data...)
+	be.PutUint32(rv, uint32(len(rv)))
+	return rv
+}
+
+// helperPNGWithICC returns PNG of uniform gray with ICC profile
+func helperPNGWithICC(t *testing.T, profile []byte) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 128}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	data := buf.Bytes()
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	_, err := w.Write(profile)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	payload := append([]byte("test\x00\x00"), compressed.Bytes()...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, "iCCP"...)
+	chunk = append(chunk, payload...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	// insert after IHDR chunk (signature + 25 bytes)
+	const ihdrEnd = 8 + 25
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func (ss *ServerSuite) TestICC() {
+	data := "data:image/png;base64," + base64.StdEncoding.EncodeToString(helperPNGWithICC(ss.T(), helperICC("Linear RGB")))
+	name, err := ss.srv.HandleBase64(data, "linear.png", Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), "Linear RGB", meta.ICC)
+
+	preview, err := imgconv.Open(filepath.Join(ss.cfg.PreviewDir, *name))
+	require.NoError(ss.T(), err)
+	r, g, b, _ := preview.At(1, 1).RGBA()
+	// linear 0.5 is 188 in sRGB
+	for _, v := range []uint32{r >> 8, g >> 8, b >> 8} {
+		assert.InDelta(ss.T(), 188, v, 1)
+	}
+	// original keeps profile
+	orig, err := readICC(filepath.Join(ss.cfg.Dir, *name))
+	require.NoError(ss.T(), err)
+	assert.NotEmpty(ss.T(), orig)
+
+	ss.srv.Config.ICCOriginal = ICCOriginalConvert
+	defer func() { ss.srv.Config.ICCOriginal = ss.cfg.ICCOriginal }()
+	name, err = ss.srv.HandleBase64(data, "linear.png", Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err = ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	orig, err = readICC(filepath.Join(ss.cfg.Dir, *name))
+	require.NoError(ss.T(), err)
+	assert.Empty(ss.T(), orig)
+	fi, err := os.Stat(filepath.Join(ss.cfg.Dir, *name))
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), fi.Size(), meta.Size)
+}
+
+func TestReadICC(t *testing.T) {
+	profile := helperICC("Linear RGB")
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "a.png")
+	require.NoError(t, os.WriteFile(file, helperPNGWithICC(t, profile), 0600))
+	data, err := readICC(file)
+	require.NoError(t, err)
+	assert.Equal(t, profile, data)
+
+	// JPEG profile split into two APP2 segments
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)), nil))
+	jpg := buf.Bytes()
+	half := len(profile) / 2
+	var segments []byte
+	for i, part := range [][]byte{profile[:half], profile[half:]} {
+		payload := append([]byte("ICC_PROFILE\x00"), byte(i+1), 2)
+		payload = append(payload, part...)
+		segments = append(segments, 0xff, 0xe2)
+		segments = binary.BigEndian.AppendUint16(segments, uint16(len(payload)+2))
+		segments = append(segments, payload...)
+	}
+	file = filepath.Join(dir, "a.jpg")
+	require.NoError(t, os.WriteFile(file, append(append([]byte{0xff, 0xd8}, segments...), jpg[2:]...), 0600))
+	data, err = readICC(file)
+	require.NoError(t, err)
+	assert.Equal(t, profile, data)
+
+	data, err = readICC(filepath.Join("../testdata", "pic.jpg"))
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	// zlib bomb
+	file = filepath.Join(dir, "bomb.png")
+	require.NoError(t, os.WriteFile(file, helperPNGWithICC(t, make([]byte, iccMaxSize+1)), 0600))
+	_, err = readICC(file)
+	assert.EqualError(t, err, ErrICCTooLarge)
+}
+
+func TestParseICC(t *testing.T) {
+	profile, err := parseICC(helperICC("sRGB IEC61966-2.1"))
+	require.NoError(t, err)
+	assert.True(t, profile.isSRGB())
+	assert.InDelta(t, 0.4360747, profile.toXYZ[0][0], 0.0001)
+	assert.InDelta(t, 0.25, profile.trc[0](0.25), 0.0001)
+
+	_, err = parseICC([]byte("short"))
+	assert.EqualError(t, err, ErrICCUnsupported)
+}
+
+func TestICCCurve(t *testing.T) {
+	be := binary.BigEndian
+	gamma := be.AppendUint16([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x01"), 2<<8)
+	curve, err := iccCurve(gamma)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.25, curve(0.5), 0.0001)
+
+	table := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\xff\xff")
+	curve, err = iccCurve(table)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, curve(0.5), 0.0001)
+
+	// sRGB parametric curve
+	para := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		para = be.AppendUint32(para, uint32(int32(v*0x10000)))
+	}
+	curve, err = iccCurve(para)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.214, curve(0.5), 0.001)
+	assert.InDelta(t, 0.01/12.92, curve(0.01), 0.0001)
+
+	_, err = iccCurve([]byte("sf32\x00\x00\x00\x00\x00\x00\x00\x00"))
+	assert.EqualError(t, err, ErrICCUnsupported)
+
+	// non-positive gamma
+	for _, g := range []int32{-0x10000, 0} {
+		_, err = iccCurve(be.AppendUint32([]byte("para\x00\x00\x00\x00\x00\x00\x00\x00"), uint32(g)))
+		assert.EqualError(t, err, ErrICCUnsupported, g)
+	}
+	_, err = iccCurve([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00"))
+	assert.EqualError(t, err, ErrICCUnsupported)
+}
+
+func TestICCConvertNaN(t *testing.T) {
+	profile, err := parseICC(helperICC("Broken"))
+	require.NoError(t, err)
+	profile.trc[0] = func(float64) float64 { return math.NaN() }
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.White)
+	rv := profile.convert(img)
+	r, _, _, _ := rv.At(0, 0).RGBA()
+	assert.Zero(t, r)
+}
+
+func FuzzICCCurve(f *testing.F) {
+	be := binary.BigEndian
+	for _, g := range []int32{-0x10000, 0, 0x26666} {
+		f.Add(be.AppendUint32([]byte("para\x00\x00\x00\x00\x00\x00\x00\x00"), uint32(g)))
+	}
+	f.Add([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x00"))
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for x := range 16 {
+		img.Set(x, 0, color.Gray{Y: uint8(x * 16)})
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		curve, err := iccCurve(data)
+		if err != nil {
+			return
+		}
+		profile, err := parseICC(helperICC("Fuzz"))
+		require.NoError(t, err)
+		profile.trc = [3]func(float64) float64{curve, curve, curve}
+		profile.convert(img)
+	})
+}
@@ -0,0 +1,359 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sunshineplan/imgconv"
+)
+
+// IIIF Image API 3.0 constants
+const (
+	IIIFContext  = "http://iiif.io/api/image/3/context.json"
+	IIIFProtocol = "http://iiif.io/api/image"
+	IIIFType     = "ImageService3"
+	IIIFProfile  = "level2"
+
+	// ErrIIIFRegion returned when IIIF region is malformed or outside of image
+	ErrIIIFRegion = "iiif: bad region"
+	// ErrIIIFSize returned when IIIF size is malformed or too large
+	ErrIIIFSize = "iiif: bad size"
+	// ErrIIIFRotation returned when IIIF rotation is malformed
+	ErrIIIFRotation = "iiif: bad rotation"
+	// ErrIIIFRotationArbitrary returned when IIIF rotation is not multiple of 90
+	ErrIIIFRotationArbitrary = "iiif: only rotation by 90 degrees is supported"
+	// ErrIIIFQuality returned when IIIF quality is unknown
+	ErrIIIFQuality = "iiif: bad quality"
+	// ErrIIIFFormat returned when IIIF format is not supported
+	ErrIIIFFormat = "iiif: unsupported format"
+)
+
+// IIIFParams holds IIIF Image API request parameters
+type IIIFParams struct {
+	Region   string
+	Size     string
+	Rotation string
+	Quality  string
+	Format   string
+}
+
+// IIIFInfo holds IIIF Image API image information (info.json)
+type IIIFInfo struct {
+	Context        string   `json:"@context"`
+	ID             string   `json:"id"`
+	Type           string   `json:"type"`
+	Protocol       string   `json:"protocol"`
+	Profile        string   `json:"profile"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	MaxWidth       int      `json:"maxWidth"`
+	MaxHeight      int      `json:"maxHeight"`
+	ExtraQualities []string `json:"extraQualities"`
+	ExtraFormats   []string `json:"extraFormats"`
+	ExtraFeatures  []string `json:"extraFeatures"`
+}
+
+// IIIFInfo returns IIIF image information of stored image. ID must be filled by caller.
+// Image size is taken from metadata, so image is not decoded.
+func (srv Service) IIIFInfo(name string) (*IIIFInfo, error) {
+	meta, err := srv.Meta(name)
+	if err != nil {
+		return nil, err
+	}
+	return &IIIFInfo{
+		Context:        IIIFContext,
+		Type:           IIIFType,
+		Protocol:       IIIFProtocol,
+		Profile:        IIIFProfile,
+		Width:          meta.Width,
+		Height:         meta.Height,
+		MaxWidth:       srv.Config.VariantMaxSize,
+		MaxHeight:      srv.Config.VariantMaxSize,
+		ExtraQualities: []string{"color", "gray", "bitonal"},
+		ExtraFormats:   []string{"gif", "webp", "tif"},
+		ExtraFeatures:  []string{"mirroring", "sizeUpscaling"},
+	}, nil
+}
+
+// IIIFImage returns stored image processed according to IIIF Image API request.
+// Results are cached as on-demand variants, cache key holds request params normalized by image size from metadata.
+func (srv Service) IIIFImage(name string, params IIIFParams) ([]byte, error) {
+	format, err := iiifFormat(params.Format)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := srv.Meta(name)
+	if err != nil {
+		return nil, err
+	}
+	region, err := iiifRegion(params.Region, image.Rect(0, 0, meta.Width, meta.Height))
+	if err != nil {
+		return nil, err
+	}
+	width, height, err := iiifSize(params.Size, region.Size(), srv.Config.VariantMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	mirror, angle, err := iiifRotation(params.Rotation)
+	if err != nil {
+		return nil, err
+	}
+	quality := params.Quality
+	switch quality {
+	case "default":
+		quality = "color"
+	case "color", "gray", "bitonal":
+	default:
+		return nil, NewHTTPError(http.StatusBadRequest, errors.New(ErrIIIFQuality))
+	}
+	rotation := strconv.Itoa(angle)
+	if mirror {
+		rotation = "!" + rotation
+	}
+	key := fmt.Sprintf("iiif-%d,%d,%d,%d-%dx%d-%s-%s-%s", region.Min.X, region.Min.Y, region.Dx(), region.Dy(),
+		width, height, rotation, quality, params.Format)
+	mark := srv.watermarkOn(WatermarkVariant)
+	if mark != nil {
+		key += "-" + mark.key
+	}
+	file := srv.variantFile(key, name)
+	if cachedVariant(file) {
+		return os.ReadFile(file) // #nosec G304, file is inside VariantDir
+	}
+
+	img, err := srv.openOriginal(name)
+	if err != nil {
+		return nil, err
+	}
+	cropped := image.NewNRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, img.Bounds().Min.Add(region.Min), draw.Src)
+	img = cropped
+	if width != region.Dx() || height != region.Dy() {
+		img = imgconv.Resize(img, &imgconv.ResizeOption{Width: width, Height: height})
+	}
+	if mirror {
+		img = flip(img, true)
+	}
+	if angle != 0 {
+		img = rotate(img, angle)
+	}
+	switch quality {
+	case "gray":
+		img = imgconv.ToGray(img)
+	case "bitonal":
+		img = bitonal(img)
+	}
+	// IIIF responses are on-demand variants too
+	if mark != nil {
+		img = mark.apply(img)
+	}
+	var buf bytes.Buffer
+	if err = imgconv.Write(&buf, img, &imgconv.FormatOption{Format: format, EncodeOption: srv.encodeOptions(PresetVariant)}); err != nil {
+		return nil, err
+	}
+	// cached file name keeps image extension for removal with image variants, key holds format
+	err = srv.saveVariant(file, func(tmp string) error {
+		return os.WriteFile(tmp, buf.Bytes(), 0600)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// openOriginal opens stored original image
+func (srv Service) openOriginal(name string) (image.Image, error) {
+	img, err := srv.openImage(filepath.Join(srv.Config.Dir, path.Clean("/"+name)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, NewHTTPError(http.StatusNotFound, errors.New(ErrNotFound))
+		}
+		srv.Log.Warnf("Open error: %v", err)
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, errors.New(ErrNotImage))
+	}
+	return img, nil
+}
+
+// iiifFormat returns image format for IIIF format name
+func iiifFormat(name string) (imgconv.Format, error) {
+	switch name {
+	case "jpg", "png", "gif", "webp", "tif":
+		return imgconv.FormatFromExtension(name)
+	}
+	return 0, NewHTTPError(http.StatusBadRequest, errors.New(ErrIIIFFormat))
+}
+
+// iiifRegion parses IIIF region and returns it clipped to image bounds
+func iiifRegion(region string, bounds image.Rectangle) (image.Rectangle, error) {
+	w, h := bounds.Dx(), bounds.Dy()
+	var rect image.Rectangle
+	switch {
+	case region == "full":
+		return bounds, nil
+	case region == "square":
+		side := min(w, h)
+		rect = image.Rect((w-side)/2, (h-side)/2, (w-side)/2+side, (h-side)/2+side)
+	case strings.HasPrefix(region, "pct:"):
+		v, err := parseFloats(strings.TrimPrefix(region, "pct:"), 4)
+		if err != nil || v[2] <= 0 || v[3] <= 0 {
+			return rect, NewHTTPError(http.StatusBadRequest, errors.New(ErrIIIFRegion))
+		}
+		rect = image.Rect(
+			int(math.Round(v[0]*float64(w)/100)),
+			int(math.Round(v[1]*float64(h)/100)),
+			int(math.Round((v[0]+v[2])*float64(w)/100)),
+			int(math.Round((v[1]+v[3])*float64(h)/100)),
+		)
+	default:
+		v, err := parseInts(region, 4)
+		if err != nil || v[2] <= 0 || v[3] <= 0 {
+			return rect, NewHTTPError(http.StatusBadRequest, errors.New(ErrIIIFRegion))
+		}
+		rect = image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3])
+	}
+	rect = rect.Add(bounds.Min).Intersect(bounds)
+	if rect.Empty() {
+		return rect, NewHTTPError(http.StatusBadRequest, errors.New(ErrIIIFRegion))
+	}
+	return rect, nil
+}
+
+// iiifSize parses IIIF size and returns result width and height for region size given
+func iiifSize(size string, region image.Point, maxSize int) (width, height int, err error) {
+	bad := NewHTTPError(http.StatusBadRequest, errors.New(ErrIIIFSize))
+	upscale := strings.HasPrefix(size, "^")
+	size = strings.TrimPrefix(size, "^")
+	rw, rh := float64(region.X), float64(region.Y)
+	switch {
+	case size == "max":
+		width, height = region.X, region.Y
+		if upscale || width > maxSize || height > maxSize {
+			scale := math.Min(float64(maxSize)/rw, float64(maxSize)/rh)
+			if !upscale {
+				scale = math.Min(scale, 1)
+			}
+			width, height = int(math.Round(rw*scale)), int(math.Round(rh*scale))
+		}
+	case strings.HasPrefix(size, "pct:"):
+		var pct float64
+		if pct, err = strconv.ParseFloat(strings.TrimPrefix(size, "pct:"), 64); err != nil || pct <= 0 {
+			return 0, 0, bad
+		}
+		width, height = int(math.Round(rw*pct/100)), int(math.Round(rh*pct/100))
+	case strings.HasPrefix(size, "!"):
+		var v []int
+		if v, err = parseInts(strings.TrimPrefix(size, "!"), 2); err != nil || v[0] <= 0 || v[1] <= 0 {
+			return 0, 0, bad
+		}
+		scale := math.Min(float64(v[0])/rw, float64(v[1])/rh)
+		if !upscale {
+			scale = math.Min(scale, 1)
+		}
+		width, height = int(math.Round(rw*scale)), int(math.Round(rh*scale))
+	default:
+		w, h, ok := strings.Cut(size, ",")
+		if !ok {
+			return 0, 0, bad
+		}
+		if w != "" {
+			if width, err = strconv.Atoi(w); err != nil {
+				return 0, 0, bad
+			}
+		}
+		if h != "" {
+			if height, err = strconv.Atoi(h); err != nil {
+				return 0, 0, bad
+			}
+		}
+		switch {
+		case w == "" && h == "":
+			return 0, 0, bad
+		case w == "":
+			width = int(math.Round(rw * float64(height) / rh))
+		case h == "":
+			height = int(math.Round(rh * float64(width) / rw))
+		}
+	}
+	width, height = max(width, 1), max(height, 1)
+	if !upscale && (width > region.X || height > region.Y) {
+		return 0, 0, bad
+	}
+	if width > maxSize || height > maxSize {
+		return 0, 0, bad
+	}
+	return width, height, nil
+}
+
+// iiifRotation parses IIIF rotation
+func iiifRotation(rotation string) (mirror bool, angle int, err error) {
+	mirror = strings.HasPrefix(rotation, "!")
+	var deg float64
+	if deg, err = strconv.ParseFloat(strings.TrimPrefix(rotation, "!"), 64); err != nil || deg < 0 || deg > 360 {
+		return false, 0, NewHTTPError(http.StatusBadRequest, errors.New(ErrIIIFRotation))
+	}
+	if math.Mod(deg, 90) != 0 {
+		return false, 0, NewHTTPError(http.StatusNotImplemented, errors.New(ErrIIIFRotationArbitrary))
+	}
+	angle = int(deg) % 360
+	return
+}
+
+// bitonal returns black and white copy of image
+func bitonal(img image.Image) image.Image {
+	b := img.Bounds()
+	rv := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y > math.MaxUint8/2 {
+				rv.SetGray(x, y, color.Gray{Y: math.MaxUint8})
+			}
+		}
+	}
+	return rv
+}
+
+// parseInts parses comma separated list of n integers
+func parseInts(s string, n int) ([]int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("%d values expected", n)
+	}
+	rv := make([]int, n)
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		rv[i] = v
+	}
+	return rv, nil
+}
+
+// parseFloats parses comma separated list of n floats
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("%d values expected", n)
+	}
+	rv := make([]float64, n)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, err
+		}
+		rv[i] = v
+	}
+	return rv, nil
+}
@@ -0,0 +1,167 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+func (ss *ServerSuite) TestIIIF() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+
+	info, err := ss.srv.IIIFInfo(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), meta.Width, info.Width)
+	assert.Equal(ss.T(), meta.Height, info.Height)
+	assert.Equal(ss.T(), IIIFProfile, info.Profile)
+
+	data, err := ss.srv.IIIFImage(*name, IIIFParams{"square", "50,", "!90", "gray", "jpg"})
+	require.NoError(ss.T(), err)
+	img, err := imgconv.Decode(bytes.NewReader(data))
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), image.Pt(50, 50), img.Bounds().Size())
+
+	_, err = ss.srv.IIIFImage(*name, IIIFParams{"full", "max", "0", "bitonal", "png"})
+	require.NoError(ss.T(), err)
+
+	tests := []struct {
+		name   string
+		file   string
+		params IIIFParams
+		status int
+	}{
+		{"Format", *name, IIIFParams{"full", "max", "0", "default", "bmp"}, http.StatusBadRequest},
+		{"Quality", *name, IIIFParams{"full", "max", "0", "sepia", "png"}, http.StatusBadRequest},
+		{"Region", *name, IIIFParams{"1,2", "max", "0", "default", "png"}, http.StatusBadRequest},
+		{"Size", *name, IIIFParams{"full", "0,0,", "0", "default", "png"}, http.StatusBadRequest},
+		{"Rotation", *name, IIIFParams{"full", "max", "45", "default", "png"}, http.StatusNotImplemented},
+		{"NotFound", "/unknown.png", IIIFParams{"full", "max", "0", "default", "png"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		_, err := ss.srv.IIIFImage(tt.file, tt.params)
+		require.NotNil(ss.T(), err, tt.name)
+		httpErr, ok := err.(interface{ Status() int })
+		assert.True(ss.T(), ok, tt.name)
+		assert.Equal(ss.T(), tt.status, httpErr.Status(), tt.name)
+	}
+	_, err = ss.srv.IIIFInfo("/unknown.png")
+	require.NotNil(ss.T(), err)
+
+	// image size is read from metadata
+	require.NoError(ss.T(), os.Remove(filepath.Join(ss.cfg.Dir, *name)))
+	info, err = ss.srv.IIIFInfo(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), meta.Width, info.Width)
+
+	// images are cached by normalized params
+	cached, err := ss.srv.IIIFImage(*name, IIIFParams{"square", "^50,", "!90.0", "gray", "jpg"})
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), data, cached)
+	_, err = ss.srv.IIIFImage(*name, IIIFParams{"full", "max", "0", "color", "png"})
+	assert.NotNil(ss.T(), err, "not cached image is decoded")
+	require.NoError(ss.T(), ss.srv.Delete(*name, Attrs{}))
+	files, err := filepath.Glob(filepath.Join(ss.cfg.VariantDir, "iiif-*", *name))
+	require.NoError(ss.T(), err)
+	assert.Empty(ss.T(), files, "cached images are removed with image")
+}
+
+func TestIIIFRegion(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	tests := []struct {
+		region string
+		rect   image.Rectangle
+		err    bool
+	}{
+		{"full", bounds, false},
+		{"square", image.Rect(50, 0, 150, 100), false},
+		{"10,20,30,40", image.Rect(10, 20, 40, 60), false},
+		{"150,50,100,100", image.Rect(150, 50, 200, 100), false},
+		{"pct:50,50,50,50", image.Rect(100, 50, 200, 100), false},
+		{"300,0,10,10", image.Rectangle{}, true},
+		{"0,0,0,10", image.Rectangle{}, true},
+		{"pct:a,0,10,10", image.Rectangle{}, true},
+	}
+	for _, tt := range tests {
+		rect, err := iiifRegion(tt.region, bounds)
+		if tt.err {
+			assert.NotNil(t, err, tt.region)
+			continue
+		}
+		require.NoError(t, err, tt.region)
+		assert.Equal(t, tt.rect, rect, tt.region)
+	}
+}
+
+func TestIIIFSize(t *testing.T) {
+	region := image.Pt(200, 100)
+	tests := []struct {
+		size   string
+		width  int
+		height int
+		err    bool
+	}{
+		{"max", 200, 100, false},
+		{"^max", 1000, 500, false},
+		{"100,", 100, 50, false},
+		{",50", 100, 50, false},
+		{"pct:50", 100, 50, false},
+		{"20,20", 20, 20, false},
+		{"!50,50", 50, 25, false},
+		{"!500,500", 200, 100, false},
+		{"^!500,500", 500, 250, false},
+		{"^400,", 400, 200, false},
+		{"400,", 0, 0, true},
+		{"^2000,", 0, 0, true},
+		{",", 0, 0, true},
+		{"x", 0, 0, true},
+		{"pct:0", 0, 0, true},
+	}
+	for _, tt := range tests {
+		w, h, err := iiifSize(tt.size, region, 1000)
+		if tt.err {
+			assert.NotNil(t, err, tt.size)
+			continue
+		}
+		require.NoError(t, err, tt.size)
+		assert.Equal(t, []int{tt.width, tt.height}, []int{w, h}, tt.size)
+	}
+}
+
+func TestIIIFRotation(t *testing.T) {
+	tests := []struct {
+		rotation string
+		mirror   bool
+		angle    int
+		status   int
+	}{
+		{"0", false, 0, 0},
+		{"!180", true, 180, 0},
+		{"360", false, 0, 0},
+		{"22.5", false, 0, http.StatusNotImplemented},
+		{"-90", false, 0, http.StatusBadRequest},
+		{"x", false, 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		mirror, angle, err := iiifRotation(tt.rotation)
+		if tt.status != 0 {
+			require.NotNil(t, err, tt.rotation)
+			assert.Equal(t, tt.status, err.(*HTTPError).Status(), tt.rotation)
+			continue
+		}
+		require.NoError(t, err, tt.rotation)
+		assert.Equal(t, tt.mirror, mirror, tt.rotation)
+		assert.Equal(t, tt.angle, angle, tt.rotation)
+	}
+}
@@ -0,0 +1,158 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// ErrNotFound returned when requested image metadata does not exists
+	ErrNotFound = "image not found"
+
+	// MetaExt holds metadata file extension
+	MetaExt = ".json"
+)
+
+// Meta holds stored image metadata
+type Meta struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Created      time.Time `json:"created"`
+	BlurHash     string    `json:"blurhash,omitempty"`
+	LQIP         string    `json:"lqip,omitempty"`
+	PHash        string    `json:"phash,omitempty"`
+	Color        string    `json:"color,omitempty"`
+	Palette      []string  `json:"palette,omitempty"`
+	ICC          string    `json:"icc,omitempty"`
+	Animated     bool      `json:"animated,omitempty"`
+	Frames       int       `json:"frames,omitempty"`
+	Pages        int       `json:"pages,omitempty"`
+	Watermark    string    `json:"watermark,omitempty"`
+	Original     string    `json:"original,omitempty"`
+	Version      int       `json:"version,omitempty"`
+	Profile      string    `json:"profile,omitempty"`
+	Tiles        bool      `json:"tiles,omitempty"`
+	SrcsetWidths []int     `json:"srcset_widths,omitempty"`
+	KeyID        string    `json:"-"` // uploader attributes are stored in metadata file only
+	Owner        string    `json:"-"`
+	IP           string    `json:"-"`
+	Private      bool      `json:"private,omitempty"`
+
+	constraints *Constraints // limits of presigned upload
+}
+
+// storedMeta holds metadata file contents
+type storedMeta struct {
+	Meta
+	KeyID string `json:"key_id,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	IP    string `json:"ip,omitempty"`
+}
+
+// readMeta reads metadata file
+func readMeta(file string) (*Meta, error) {
+	data, err := os.ReadFile(file) // #nosec G304, file is inside MetaDir
+	if err != nil {
+		return nil, err
+	}
+	stored := storedMeta{}
+	if err = json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	meta := stored.Meta
+	meta.KeyID, meta.Owner, meta.IP = stored.KeyID, stored.Owner, stored.IP
+	return &meta, nil
+}
+
+// Meta returns metadata of stored image
+func (srv Service) Meta(name string) (*Meta, error) {
+	meta, err := readMeta(srv.metaFile(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, NewHTTPError(http.StatusNotFound, errors.New(ErrNotFound))
+		}
+		return nil, err
+	}
+	return meta, nil
+}
+
+// List returns metadata of stored images accessible by caller
+func (srv Service) List(attrs Attrs) ([]Meta, error) {
+	list, err := srv.list()
+	if err != nil {
+		return nil, err
+	}
+	rv := []Meta{}
+	for _, meta := range list {
+		if attrs.owns(&meta) {
+			rv = append(rv, meta)
+		}
+	}
+	return rv, nil
+}
+
+// list returns metadata of all stored images
+func (srv Service) list() ([]Meta, error) {
+	rv := []Meta{}
+	err := filepath.WalkDir(srv.Config.MetaDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// nothing stored yet
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(file, MetaExt) {
+			return nil
+		}
+		meta, err := readMeta(file)
+		if err != nil {
+			return err
+		}
+		rv = append(rv, *meta)
+		return nil
+	})
+	return rv, err
+}
+
+// OwnedMeta returns metadata of stored image if caller owns it.
+// Images of other owners are reported as not found, so their names are not disclosed.
+func (srv Service) OwnedMeta(name string, attrs Attrs) (*Meta, error) {
+	meta, err := srv.Meta(name)
+	if err != nil {
+		return nil, err
+	}
+	if !attrs.owns(meta) {
+		return nil, NewHTTPError(http.StatusNotFound, errors.New(ErrNotFound))
+	}
+	return meta, nil
+}
+
+// saveMeta writes image metadata into MetaDir
+func (srv Service) saveMeta(meta *Meta) error {
+	file := srv.metaFile(meta.Name)
+	// name may contains random dir, ensure dir exists anyway
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	}
+	data, err := json.Marshal(storedMeta{Meta: *meta, KeyID: meta.KeyID, Owner: meta.Owner, IP: meta.IP})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0600)
+}
+
+// metaFile returns metadata filename for image name
+func (srv Service) metaFile(name string) string {
+	// path.Clean with leading slash drops all '..' elements
+	return filepath.Join(srv.Config.MetaDir, path.Clean("/"+name)+MetaExt)
+}
@@ -0,0 +1,147 @@
+package upload
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (ss *ServerSuite) TestOwner() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{Owner: "alice"})
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), strings.HasPrefix(*name, "/alice/"), *name)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), "alice", meta.Owner)
+	_, err = os.Stat(filepath.Join(ss.cfg.PreviewDir, *name))
+	assert.NoError(ss.T(), err)
+
+	// owner is stored but not shown in API responses
+	data, err := json.Marshal(meta)
+	require.NoError(ss.T(), err)
+	assert.NotContains(ss.T(), string(data), `"owner"`)
+	data, err = os.ReadFile(ss.srv.metaFile(*name))
+	require.NoError(ss.T(), err)
+	assert.Contains(ss.T(), string(data), `"owner":"alice"`)
+}
+
+func TestOwnerDir(t *testing.T) {
+	assert.Equal(t, "", OwnerDir(""))
+	assert.Equal(t, "alice@example.com", OwnerDir("alice@example.com"))
+	for _, owner := range []string{"auth0|123", "../etc", ".hidden", "a/b", strings.Repeat("x", 65)} {
+		dir := OwnerDir(owner)
+		assert.Regexp(t, `^u[0-9a-f]{32}$`, dir, owner)
+	}
+	assert.NotEqual(t, OwnerDir("a/b"), OwnerDir("a|b"))
+	assert.Equal(t, "key/alice", OwnerDir("key:alice"))
+	assert.Equal(t, "user/alice", OwnerDir("user:alice"))
+	assert.Regexp(t, `^u[0-9a-f]{32}$`, OwnerDir("user:../etc"))
+	assert.Regexp(t, `^u[0-9a-f]{32}$`, OwnerDir("Key:alice"))
+}
+
+func (ss *ServerSuite) TestOwnership() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	alice, bob, admin := Attrs{Owner: "alice"}, Attrs{Owner: "bob"}, Attrs{Owner: "root", Admin: true}
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, alice)
+	require.NoError(ss.T(), err)
+	ops := []Operation{{Op: OpGrayscale}}
+
+	_, err = ss.srv.Edit(*name, ops, bob)
+	assert.EqualError(ss.T(), err, ErrNotFound)
+	assert.EqualError(ss.T(), ss.srv.Delete(*name, bob), ErrNotFound)
+	list, err := ss.srv.List(bob)
+	require.NoError(ss.T(), err)
+	for _, meta := range list {
+		assert.NotEqual(ss.T(), *name, meta.Name)
+	}
+	_, err = ss.srv.OwnedMeta(*name, bob)
+	assert.EqualError(ss.T(), err, ErrNotFound)
+	_, err = ss.srv.Similar(*name, 64, bob)
+	assert.EqualError(ss.T(), err, ErrNotFound)
+	_, err = ss.srv.Sheet(SheetRequest{Names: []string{*name}}, bob)
+	assert.EqualError(ss.T(), err, ErrNotFound)
+	sheet, err := ss.srv.Sheet(SheetRequest{Limit: MaxSheetTiles}, bob)
+	if err == nil {
+		assert.NotContains(ss.T(), sheet.Tiles, *name)
+	}
+	matches, err := ss.srv.Similar(*name, 64, alice)
+	require.NoError(ss.T(), err)
+	for _, m := range matches {
+		assert.Equal(ss.T(), "alice", m.Owner)
+	}
+
+	list, err = ss.srv.List(alice)
+	require.NoError(ss.T(), err)
+	require.NotEmpty(ss.T(), list)
+	for _, meta := range list {
+		assert.Equal(ss.T(), "alice", meta.Owner)
+	}
+
+	// admin edit keeps owner
+	v2, err := ss.srv.Edit(*name, ops, admin)
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), strings.HasPrefix(*v2, "/alice/"), *v2)
+	meta, err := ss.srv.Meta(*v2)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), "alice", meta.Owner)
+
+	assert.NoError(ss.T(), ss.srv.Delete(*v2, alice))
+	assert.NoError(ss.T(), ss.srv.Delete(*name, admin))
+}
+
+func (ss *ServerSuite) TestPrivate() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	public, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	private, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{Private: true})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*private)
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), meta.Private)
+
+	// edited version stays private
+	edited, err := ss.srv.Edit(*private, []Operation{{Op: "grayscale"}}, Attrs{Admin: true})
+	require.NoError(ss.T(), err)
+	meta, err = ss.srv.Meta(*edited)
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), meta.Private)
+
+	// private images are not listed in sheets
+	sheet, err := ss.srv.Sheet(SheetRequest{Limit: 1}, Attrs{})
+	require.NoError(ss.T(), err)
+	assert.Contains(ss.T(), sheet.Tiles, *public)
+	_, err = ss.srv.Sheet(SheetRequest{Names: []string{*private}}, Attrs{})
+	assert.EqualError(ss.T(), err, ErrNotFound)
+
+	// private images are found in similar search by owner and admin only
+	owned, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{Owner: "alice", Private: true})
+	require.NoError(ss.T(), err)
+	for _, tt := range []struct {
+		name  string
+		attrs Attrs
+		found bool
+	}{
+		{"Owner", Attrs{Owner: "alice"}, true},
+		{"Admin", Attrs{Admin: true}, true},
+		{"Other", Attrs{Owner: "bob"}, false},
+	} {
+		src, err := ss.srv.HandleBase64(js.Data, js.Name, tt.attrs)
+		require.NoError(ss.T(), err, tt.name)
+		list, err := ss.srv.Similar(*src, 0, tt.attrs)
+		require.NoError(ss.T(), err, tt.name)
+		found := false
+		for _, m := range list {
+			found = found || m.Name == *owned
+		}
+		assert.Equal(ss.T(), tt.found, found, tt.name)
+	}
+}
@@ -0,0 +1,223 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sunshineplan/imgconv"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+const (
+	// ErrPageNotFound returned when requested page of image does not exists
+	ErrPageNotFound = "image page not found"
+
+	// MaxPages holds max pages count of multi-page image
+	MaxPages = 1000
+
+	icoHeaderLen   = 6
+	icoEntryLen    = 16
+	dibHeaderLen   = 40
+	bmpFileHeadLen = 14
+)
+
+// Page returns page of stored multi-page image (TIFF, ICO) encoded as PNG.
+// Pages are numbered from 1, single page images have page 1 only.
+func (srv Service) Page(name string, page int) ([]byte, error) {
+	file := filepath.Join(srv.Config.Dir, path.Clean("/"+name))
+	var img image.Image
+	var err error
+	if hasPages(file) {
+		img, err = decodePage(file, page)
+	} else if page == 1 {
+		img, err = srv.openImage(file)
+	} else {
+		err = errors.New(ErrPageNotFound)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, NewHTTPError(http.StatusNotFound, errors.New(ErrNotFound))
+		case err.Error() == ErrPageNotFound:
+			return nil, NewHTTPError(http.StatusNotFound, err)
+		}
+		srv.Log.Warnf("Page open error: %v", err)
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, errors.New(ErrNotImage))
+	}
+	var buf bytes.Buffer
+	if err = imgconv.Write(&buf, img, &imgconv.FormatOption{Format: imgconv.PNG}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hasPages checks if file format may contain several pages
+func hasPages(file string) bool {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".tif", ".tiff", ".ico":
+		return true
+	}
+	return false
+}
+
+// pageCount returns pages count of image file
+func pageCount(file string) (int, error) {
+	if !hasPages(file) {
+		return 1, nil
+	}
+	data, err := os.ReadFile(file) // #nosec G304, file is stored image
+	if err != nil {
+		return 0, err
+	}
+	if isICO(file) {
+		entries, err := icoEntries(data)
+		return len(entries), err
+	}
+	offsets, _, err := tiffPages(data)
+	return len(offsets), err
+}
+
+// decodePage decodes page of multi-page image file, page number is limited by pages count
+func decodePage(file string, page int) (image.Image, error) {
+	data, err := os.ReadFile(file) // #nosec G304, file is stored image
+	if err != nil {
+		return nil, err
+	}
+	if isICO(file) {
+		entries, err := icoEntries(data)
+		if err != nil {
+			return nil, err
+		}
+		if page < 1 || page > len(entries) {
+			return nil, errors.New(ErrPageNotFound)
+		}
+		return decodeICOEntry(entries[page-1])
+	}
+	offsets, order, err := tiffPages(data)
+	if err != nil {
+		return nil, err
+	}
+	if page < 1 || page > len(offsets) {
+		return nil, errors.New(ErrPageNotFound)
+	}
+	// decoder reads first IFD only, so point header to requested one
+	order.PutUint32(data[4:8], offsets[page-1])
+	return tiff.Decode(bytes.NewReader(data))
+}
+
+// isICO checks if file is ICO image by its extension
+func isICO(file string) bool {
+	return strings.EqualFold(path.Ext(file), ".ico")
+}
+
+// tiffPages returns offsets of TIFF image file directories (one per page)
+func tiffPages(data []byte) (offsets []uint32, order binary.ByteOrder, err error) {
+	if len(data) < 8 {
+		return nil, nil, image.ErrFormat
+	}
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, nil, image.ErrFormat
+	}
+	for off := order.Uint32(data[4:8]); off != 0 && len(offsets) < MaxPages; {
+		if uint64(off)+2 > uint64(len(data)) {
+			return nil, nil, image.ErrFormat
+		}
+		offsets = append(offsets, off)
+		next := uint64(off) + 2 + uint64(order.Uint16(data[off:]))*12
+		if next+4 > uint64(len(data)) {
+			return nil, nil, image.ErrFormat
+		}
+		if off = order.Uint32(data[next:]); off <= offsets[len(offsets)-1] {
+			break // IFDs must go forward, this also prevents loops
+		}
+	}
+	if len(offsets) == 0 {
+		return nil, nil, image.ErrFormat
+	}
+	return offsets, order, nil
+}
+
+// icoEntries returns image data of ICO directory entries
+func icoEntries(data []byte) ([][]byte, error) {
+	if len(data) < icoHeaderLen || binary.LittleEndian.Uint16(data) != 0 {
+		return nil, image.ErrFormat
+	}
+	if kind := binary.LittleEndian.Uint16(data[2:]); kind != 1 && kind != 2 { // icon or cursor
+		return nil, image.ErrFormat
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if count == 0 || count > MaxPages || icoHeaderLen+count*icoEntryLen > len(data) {
+		return nil, image.ErrFormat
+	}
+	rv := make([][]byte, count)
+	for i := range rv {
+		entry := data[icoHeaderLen+i*icoEntryLen:]
+		size, offset := binary.LittleEndian.Uint32(entry[8:]), binary.LittleEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, image.ErrFormat
+		}
+		rv[i] = data[offset : offset+size]
+	}
+	return rv, nil
+}
+
+// decodeICOEntry decodes ICO entry stored as PNG or as BMP without file header
+func decodeICOEntry(data []byte) (image.Image, error) {
+	if bytes.HasPrefix(data, []byte("\x89PNG")) {
+		return png.Decode(bytes.NewReader(data))
+	}
+	if len(data) < dibHeaderLen {
+		return nil, image.ErrFormat
+	}
+	le := binary.LittleEndian
+	headerLen := le.Uint32(data)
+	width, height := int(int32(le.Uint32(data[4:]))), int(int32(le.Uint32(data[8:])))/2 // height includes AND mask
+	bpp, compression := le.Uint16(data[14:]), le.Uint32(data[16:])
+	if headerLen < dibHeaderLen || width <= 0 || height <= 0 || uint64(headerLen) > uint64(len(data)) {
+		return nil, image.ErrFormat
+	}
+	if bpp == 32 && compression == 0 {
+		// BMP decoder ignores alpha of 32 bit images with short header
+		pixels := data[headerLen:]
+		if len(pixels) < width*height*4 {
+			return nil, image.ErrFormat
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			row := pixels[(height-1-y)*width*4:] // rows are stored bottom-up
+			for x := 0; x < width; x++ {
+				p := row[x*4:]
+				img.SetNRGBA(x, y, color.NRGBA{R: p[2], G: p[1], B: p[0], A: p[3]})
+			}
+		}
+		return img, nil
+	}
+	// add BMP file header and fix height
+	colors := le.Uint32(data[32:])
+	if colors == 0 && bpp <= 8 {
+		colors = 1 << bpp
+	}
+	buf := make([]byte, bmpFileHeadLen+len(data))
+	copy(buf, "BM")
+	le.PutUint32(buf[2:], uint32(len(buf)))
+	le.PutUint32(buf[10:], bmpFileHeadLen+headerLen+colors*4)
+	copy(buf[bmpFileHeadLen:], data)
+	le.PutUint32(buf[bmpFileHeadLen+8:], uint32(height))
+	return bmp.Decode(bytes.NewReader(buf))
+}
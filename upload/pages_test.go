@@ -0,0 +1,151 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+// helperTIFF returns uncompressed grayscale TIFF with page per size given
+func helperTIFF(sizes ...image.Point) []byte {
+	le := binary.LittleEndian
+	buf := []byte("II*\x00\x00\x00\x00\x00")
+	link := 4 // offset of previous IFD link
+	for _, size := range sizes {
+		pixels := len(buf)
+		buf = append(buf, bytes.Repeat([]byte{0x80}, size.X*size.Y)...)
+		if len(buf)%2 == 1 {
+			buf = append(buf, 0)
+		}
+		le.PutUint32(buf[link:], uint32(len(buf)))
+		tags := [][2]uint32{{256, uint32(size.X)}, {257, uint32(size.Y)}, {258, 8}, {259, 1}, {262, 1},
+			{273, uint32(pixels)}, {277, 1}, {278, uint32(size.Y)}, {279, uint32(size.X * size.Y)}}
+		buf = le.AppendUint16(buf, uint16(len(tags)))
+		for _, tag := range tags {
+			buf = le.AppendUint16(buf, uint16(tag[0]))
+			buf = le.AppendUint16(buf, 4) // LONG
+			buf = le.AppendUint32(buf, 1)
+			buf = le.AppendUint32(buf, tag[1])
+		}
+		link = len(buf)
+		buf = le.AppendUint32(buf, 0)
+	}
+	return buf
+}
+
+// helperICO returns ICO with PNG entry and 32 bit BMP entry
+func helperICO(t *testing.T, pngSize, bmpSize int) []byte {
+	le := binary.LittleEndian
+	var pngData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, image.NewNRGBA(image.Rect(0, 0, pngSize, pngSize))))
+	dib := make([]byte, dibHeaderLen)
+	le.PutUint32(dib, dibHeaderLen)
+	le.PutUint32(dib[4:], uint32(bmpSize))
+	le.PutUint32(dib[8:], uint32(bmpSize*2))
+	le.PutUint16(dib[12:], 1)
+	le.PutUint16(dib[14:], 32)
+	for i := 0; i < bmpSize*bmpSize; i++ {
+		dib = append(dib, 0xff, 0, 0, 0x80) // semi transparent blue
+	}
+	dib = append(dib, make([]byte, bmpSize*4)...) // AND mask
+
+	entries := [][]byte{pngData.Bytes(), dib}
+	buf := []byte{0, 0, 1, 0, byte(len(entries)), 0}
+	offset := icoHeaderLen + len(entries)*icoEntryLen
+	for _, data := range entries {
+		buf = append(buf, 0, 0, 0, 0)
+		buf = le.AppendUint16(buf, 1)
+		buf = le.AppendUint16(buf, 32)
+		buf = le.AppendUint32(buf, uint32(len(data)))
+		buf = le.AppendUint32(buf, uint32(offset))
+		offset += len(data)
+	}
+	for _, data := range entries {
+		buf = append(buf, data...)
+	}
+	return buf
+}
+
+func (ss *ServerSuite) TestPages() {
+	data := "data:image/tiff;base64," + base64.StdEncoding.EncodeToString(helperTIFF(image.Pt(30, 20), image.Pt(40, 50)))
+	ss.srv.Config.PreviewPage = 2
+	defer func() { ss.srv.Config.PreviewPage = ss.cfg.PreviewPage }()
+	name, err := ss.srv.HandleBase64(data, "doc.tif", Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 2, meta.Pages)
+	assert.Equal(ss.T(), 40, meta.Width, "preview page is used")
+
+	page, err := ss.srv.Page(*name, 1)
+	require.NoError(ss.T(), err)
+	img, err := imgconv.Decode(bytes.NewReader(page))
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), image.Pt(30, 20), img.Bounds().Size())
+
+	data = "data:image/x-icon;base64," + base64.StdEncoding.EncodeToString(helperICO(ss.T(), 16, 32))
+	name, err = ss.srv.HandleBase64(data, "favicon.ico", Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err = ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 2, meta.Pages)
+	assert.Equal(ss.T(), 32, meta.Width)
+	_, err = os.Stat(filepath.Join(ss.cfg.PreviewDir, *name+RasterExt))
+	assert.NoError(ss.T(), err)
+
+	tests := []struct {
+		name   string
+		file   string
+		page   int
+		status int
+	}{
+		{"NoPage", *name, 3, http.StatusNotFound},
+		{"ZeroPage", *name, 0, http.StatusNotFound},
+		{"NotFound", "/unknown.ico", 1, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		_, err := ss.srv.Page(tt.file, tt.page)
+		require.NotNil(ss.T(), err, tt.name)
+		assert.Equal(ss.T(), tt.status, err.(*HTTPError).Status(), tt.name)
+	}
+}
+
+func TestTIFFPages(t *testing.T) {
+	offsets, _, err := tiffPages(helperTIFF(image.Pt(2, 2), image.Pt(3, 3), image.Pt(4, 4)))
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(offsets))
+
+	for _, data := range []string{"", "II*\x00", "XX*\x00\x08\x00\x00\x00", "II*\x00\xff\x00\x00\x00"} {
+		_, _, err = tiffPages([]byte(data))
+		assert.Equal(t, image.ErrFormat, err, data)
+	}
+}
+
+func TestDecodeICOEntry(t *testing.T) {
+	entries, err := icoEntries(helperICO(t, 16, 8))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	img, err := decodeICOEntry(entries[0])
+	require.NoError(t, err)
+	assert.Equal(t, image.Pt(16, 16), img.Bounds().Size())
+	img, err = decodeICOEntry(entries[1])
+	require.NoError(t, err)
+	assert.Equal(t, image.Pt(8, 8), img.Bounds().Size())
+	assert.Equal(t, color.NRGBA{0, 0, 0xff, 0x80}, color.NRGBAModel.Convert(img.At(1, 1)))
+
+	for _, data := range []string{"", "\x00\x00\x03\x00\x01\x00", "\x00\x00\x01\x00\x05\x00"} {
+		_, err = icoEntries([]byte(data))
+		assert.Equal(t, image.ErrFormat, err, data)
+	}
+}
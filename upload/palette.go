@@ -0,0 +1,109 @@
+package upload
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// PaletteSourceWidth holds width of image used for palette extraction
+	PaletteSourceWidth = 64
+	// paletteMinAlpha holds min alpha of pixel used in palette extraction
+	paletteMinAlpha = 0x80
+)
+
+// colorBox holds pixels of median cut box
+type colorBox []color.NRGBA
+
+// extractPalette returns dominant color and palette of up to k colors (most frequent first)
+// calculated by median cut. Colors are formatted as #rrggbb.
+func extractPalette(img image.Image, k int) (dominant string, rv []string) {
+	small := imgconv.Resize(img, &imgconv.ResizeOption{Width: PaletteSourceWidth})
+	b := small.Bounds()
+	var pixels colorBox
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(small.At(x, y)).(color.NRGBA)
+			if c.A >= paletteMinAlpha {
+				pixels = append(pixels, c)
+			}
+		}
+	}
+	if len(pixels) == 0 || k < 1 {
+		return
+	}
+	boxes := []colorBox{pixels}
+	for len(boxes) < k {
+		// split box with widest channel range
+		idx, channel, width := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if ch, w := box.widest(); w > width {
+				idx, channel, width = i, ch, w
+			}
+		}
+		if idx < 0 {
+			break // all boxes contain single color
+		}
+		box := boxes[idx]
+		slices.SortFunc(box, func(a, b color.NRGBA) int {
+			return int(channelOf(a, channel)) - int(channelOf(b, channel))
+		})
+		// split at the middle of channel range, so same colors are never divided
+		lo, hi := channelOf(box[0], channel), channelOf(box[len(box)-1], channel)
+		mid, _ := slices.BinarySearchFunc(box, lo+(hi-lo)/2+1, func(c color.NRGBA, v uint8) int {
+			return int(channelOf(c, channel)) - int(v)
+		})
+		boxes[idx] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+	slices.SortStableFunc(boxes, func(a, b colorBox) int { return len(b) - len(a) })
+	for _, box := range boxes {
+		rv = append(rv, box.average())
+	}
+	return rv[0], slices.Compact(rv)
+}
+
+// widest returns channel with max values range and this range
+func (box colorBox) widest() (channel, width int) {
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, c := range box {
+			v := channelOf(c, ch)
+			lo, hi = min(lo, v), max(hi, v)
+		}
+		if w := int(hi) - int(lo); w > width {
+			channel, width = ch, w
+		}
+	}
+	return
+}
+
+// average returns average box color formatted as #rrggbb
+func (box colorBox) average() string {
+	var r, g, b int
+	for _, c := range box {
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+	}
+	n := len(box)
+	return fmt.Sprintf("#%02x%02x%02x", (r+n/2)/n, (g+n/2)/n, (b+n/2)/n)
+}
+
+// channelOf returns color channel value by index (0 - red, 1 - green, 2 - blue)
+func channelOf(c color.NRGBA, channel int) uint8 {
+	switch channel {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	}
+	return c.B
+}
@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (ss *ServerSuite) TestPalette() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Regexp(ss.T(), `^#[0-9a-f]{6}$`, meta.Color)
+	require.NotEmpty(ss.T(), meta.Palette)
+	assert.LessOrEqual(ss.T(), len(meta.Palette), ss.cfg.PaletteSize)
+	assert.Equal(ss.T(), meta.Color, meta.Palette[0])
+}
+
+func TestPaletteColors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, PaletteSourceWidth, PaletteSourceWidth))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{0xff, 0, 0, 0xff}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, PaletteSourceWidth, PaletteSourceWidth/4), image.NewUniform(color.NRGBA{0, 0, 0xff, 0xff}), image.Point{}, draw.Src)
+	dominant, colors := extractPalette(img, 2)
+	assert.Equal(t, "#ff0000", dominant)
+	assert.Equal(t, []string{"#ff0000", "#0000ff"}, colors)
+
+	// uniform image has single color palette
+	white := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(white, white.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	dominant, colors = extractPalette(white, 5)
+	assert.Equal(t, "#ffffff", dominant)
+	assert.Equal(t, []string{"#ffffff"}, colors)
+
+	// transparent pixels are ignored
+	dominant, colors = extractPalette(image.NewNRGBA(image.Rect(0, 0, 10, 10)), 5)
+	assert.Equal(t, "", dominant)
+	assert.Empty(t, colors)
+}
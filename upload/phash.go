@@ -0,0 +1,127 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// ErrBadDistance returned when requested Hamming distance is out of range
+	ErrBadDistance = "distance must be in range 0..64"
+	// ErrNoHash returned when stored image has no perceptual hash
+	ErrNoHash = "image hash not found"
+
+	// dHashWidth holds width of image used for difference hash calculation
+	dHashWidth = 9
+	// dHashHeight holds height of image used for difference hash calculation
+	dHashHeight = 8
+)
+
+// Match holds similar image metadata with its distance from the sample
+type Match struct {
+	Meta
+	Distance int `json:"distance"`
+}
+
+// Similar returns caller's stored images within given Hamming distance of stored image
+func (srv Service) Similar(name string, distance int, attrs Attrs) ([]Match, error) {
+	meta, err := srv.OwnedMeta(name, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if meta.PHash == "" {
+		// image was stored before hash calculation was implemented
+		return nil, NewHTTPError(http.StatusUnprocessableEntity, errors.New(ErrNoHash))
+	}
+	hash, err := strconv.ParseUint(meta.PHash, 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	return srv.similar(hash, distance, meta.Name, attrs)
+}
+
+// SimilarFile returns caller's stored images within given Hamming distance of image from multipart form
+func (srv Service) SimilarFile(form *multipart.Form, distance int, attrs Attrs) ([]Match, error) {
+	files, ok := form.File["file"]
+	if !ok || len(files) != 1 {
+		return nil, NewHTTPError(
+			http.StatusBadRequest,
+			errors.New(ErrNoSingleFile),
+		)
+	}
+	src, err := files[0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	img, err := imgconv.Decode(src)
+	if err != nil {
+		srv.Log.Warnf("Decode error: %v", err)
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, errors.New(ErrNotImage))
+	}
+	return srv.similar(dHash(img), distance, "", attrs)
+}
+
+// similar returns caller's stored images (except skipped one) sorted by distance from hash.
+// Private images are included, they are available to owner and admin only anyway.
+func (srv Service) similar(hash uint64, distance int, skip string, attrs Attrs) ([]Match, error) {
+	if distance < 0 || distance > 64 {
+		return nil, NewHTTPError(http.StatusBadRequest, errors.New(ErrBadDistance))
+	}
+	list, err := srv.list()
+	if err != nil {
+		return nil, err
+	}
+	rv := []Match{}
+	for _, meta := range list {
+		if meta.Name == skip || meta.PHash == "" || !attrs.owns(&meta) {
+			continue
+		}
+		h, err := strconv.ParseUint(meta.PHash, 16, 64)
+		if err != nil {
+			srv.Log.Warnf("Bad hash of %s: %v", meta.Name, err)
+			continue
+		}
+		if d := hashDistance(hash, h); d <= distance {
+			rv = append(rv, Match{Meta: meta, Distance: d})
+		}
+	}
+	sort.SliceStable(rv, func(i, j int) bool { return rv[i].Distance < rv[j].Distance })
+	return rv, nil
+}
+
+// dHash returns 64 bit difference hash of image
+func dHash(img image.Image) (hash uint64) {
+	small := imgconv.Resize(img, &imgconv.ResizeOption{Width: dHashWidth, Height: dHashHeight})
+	b := small.Bounds()
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			left := color.GrayModel.Convert(small.At(b.Min.X+x, b.Min.Y+y)).(color.Gray)
+			right := color.GrayModel.Convert(small.At(b.Min.X+x+1, b.Min.Y+y)).(color.Gray)
+			hash <<= 1
+			if left.Y > right.Y {
+				hash |= 1
+			}
+		}
+	}
+	return
+}
+
+// formatHash returns hash as fixed width hex string
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// hashDistance returns Hamming distance between two hashes
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,35 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+
+	"github.com/buckket/go-blurhash"
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// BlurHashSourceWidth holds width of image used for BlurHash calculation
+	BlurHashSourceWidth = 32
+
+	// LQIPPrefix holds LQIP data URI prefix
+	LQIPPrefix = "data:image/png;base64,"
+)
+
+// placeholders calculates BlurHash string and LQIP data URI for image
+func (srv Service) placeholders(img image.Image) (hash, lqip string, err error) {
+	cfg := srv.Config
+	// BlurHash does not need details, so calculate it from small copy
+	small := imgconv.Resize(img, &imgconv.ResizeOption{Width: BlurHashSourceWidth})
+	if hash, err = blurhash.Encode(cfg.BlurHashX, cfg.BlurHashY, small); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	tiny := imgconv.Resize(img, &imgconv.ResizeOption{Width: cfg.LQIPWidth})
+	if err = imgconv.Write(&buf, tiny, &imgconv.FormatOption{Format: imgconv.PNG}); err != nil {
+		return
+	}
+	lqip = LQIPPrefix + base64.StdEncoding.EncodeToString(buf.Bytes())
+	return
+}
@@ -0,0 +1,160 @@
+package upload
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+const (
+	// ErrQuotaExceeded returned when owner or IP has no space left
+	ErrQuotaExceeded = "storage quota exceeded"
+	// ErrQuotaTooLarge returned when image does not fit into space left
+	ErrQuotaTooLarge = "image exceeds storage quota"
+)
+
+// UsageStat holds stored images count and size with their limits (0 - unlimited)
+type UsageStat struct {
+	Files    int   `json:"files"`
+	Bytes    int64 `json:"bytes"`
+	MaxFiles int   `json:"max_files,omitempty"`
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
+
+// Usage holds storage usage of caller
+type Usage struct {
+	Owner   string     `json:"owner,omitempty"`
+	IP      string     `json:"ip,omitempty"`
+	ByOwner *UsageStat `json:"by_owner,omitempty"`
+	ByIP    *UsageStat `json:"by_ip,omitempty"`
+}
+
+// usageIndex holds stored images count and size by owner and IP
+type usageIndex struct {
+	mu     sync.Mutex
+	loaded bool
+	owners map[string]UsageStat
+	ips    map[string]UsageStat
+}
+
+// newUsageIndex creates usage index which is loaded on first use
+func newUsageIndex() *usageIndex {
+	return &usageIndex{owners: map[string]UsageStat{}, ips: map[string]UsageStat{}}
+}
+
+// usage returns loaded usage index of service storage, caller must unlock it
+func (srv Service) usage() (*usageIndex, error) {
+	index := srv.usageIndex
+	index.mu.Lock()
+	if !index.loaded {
+		list, err := srv.list()
+		if err != nil {
+			index.mu.Unlock()
+			return nil, err
+		}
+		for _, meta := range list {
+			index.add(meta.Owner, meta.IP, 1, meta.Size)
+		}
+		index.loaded = true
+	}
+	return index, nil
+}
+
+// add changes usage counters of owner and ip
+func (index *usageIndex) add(owner, ip string, files int, bytes int64) {
+	if owner != "" {
+		stat := index.owners[owner]
+		stat.Files += files
+		stat.Bytes += bytes
+		index.owners[owner] = stat
+	}
+	if ip != "" {
+		stat := index.ips[ip]
+		stat.Files += files
+		stat.Bytes += bytes
+		index.ips[ip] = stat
+	}
+}
+
+// checkQuota returns error if image of size does not fit into quota
+func checkQuota(stat UsageStat, size int64) error {
+	if (stat.MaxFiles > 0 && stat.Files >= stat.MaxFiles) || (stat.MaxBytes > 0 && stat.Bytes >= stat.MaxBytes) {
+		return NewHTTPError(http.StatusInsufficientStorage, errors.New(ErrQuotaExceeded))
+	}
+	if stat.MaxBytes > 0 && stat.Bytes+size > stat.MaxBytes {
+		return NewHTTPError(http.StatusRequestEntityTooLarge, errors.New(ErrQuotaTooLarge))
+	}
+	return nil
+}
+
+// reserveQuota checks quota of meta owner and IP and reserves size for image.
+// It also returns max image size allowed by quota (-1 if unlimited), which includes reserved size.
+// Returned func must be called with actual size after image is saved or with -1 if save failed.
+func (srv Service) reserveQuota(meta *Meta, size int64) (func(int64), int64, error) {
+	index, err := srv.usage()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer index.mu.Unlock()
+	usage := srv.usageOf(index, meta.Owner, meta.IP)
+	left := int64(-1)
+	for _, stat := range []*UsageStat{usage.ByOwner, usage.ByIP} {
+		if stat == nil {
+			continue
+		}
+		if err = checkQuota(*stat, max(size, 0)); err != nil {
+			return nil, 0, err
+		}
+		if stat.MaxBytes > 0 && (left < 0 || stat.MaxBytes-stat.Bytes < left) {
+			left = stat.MaxBytes - stat.Bytes
+		}
+	}
+	index.add(meta.Owner, meta.IP, 1, size)
+	return func(actual int64) {
+		index.mu.Lock()
+		defer index.mu.Unlock()
+		if actual < 0 {
+			index.add(meta.Owner, meta.IP, -1, -size)
+			return
+		}
+		index.add(meta.Owner, meta.IP, 0, actual-size)
+	}, left, nil
+}
+
+// releaseQuota removes deleted image from usage index
+func (srv Service) releaseQuota(meta *Meta) error {
+	index, err := srv.usage()
+	if err != nil {
+		return err
+	}
+	defer index.mu.Unlock()
+	index.add(meta.Owner, meta.IP, -1, -meta.Size)
+	return nil
+}
+
+// usageOf returns usage of owner and ip from locked index
+func (srv Service) usageOf(index *usageIndex, owner, ip string) *Usage {
+	cfg := srv.Config
+	rv := &Usage{Owner: owner, IP: ip}
+	if owner != "" {
+		stat := index.owners[owner]
+		stat.MaxFiles, stat.MaxBytes = cfg.QuotaFiles, cfg.QuotaSize<<20
+		rv.ByOwner = &stat
+	}
+	if ip != "" {
+		stat := index.ips[ip]
+		stat.MaxFiles, stat.MaxBytes = cfg.IPQuotaFiles, cfg.IPQuotaSize<<20
+		rv.ByIP = &stat
+	}
+	return rv
+}
+
+// Usage returns storage usage and quotas of caller
+func (srv Service) Usage(attrs Attrs) (*Usage, error) {
+	index, err := srv.usage()
+	if err != nil {
+		return nil, err
+	}
+	defer index.mu.Unlock()
+	return srv.usageOf(index, attrs.Owner, attrs.IP), nil
+}
@@ -0,0 +1,117 @@
+package upload
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (ss *ServerSuite) TestQuota() {
+	// separate storage, so usage of other tests is not counted
+	cfg := *ss.srv.Config
+	root := ss.T().TempDir()
+	cfg.Dir, cfg.PreviewDir, cfg.MetaDir = filepath.Join(root, "img"), filepath.Join(root, "preview"), filepath.Join(root, "meta")
+	cfg.VariantDir = filepath.Join(root, "variant")
+	cfg.IPQuotaFiles, cfg.QuotaSize = 2, 1
+	srv, err := New(cfg, ss.srv.Log)
+	require.NoError(ss.T(), err)
+
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	alice := Attrs{Owner: "alice", IP: "192.0.2.1"}
+	first, err := srv.HandleBase64(js.Data, js.Name, alice)
+	require.NoError(ss.T(), err)
+	_, err = srv.HandleBase64(js.Data, js.Name, Attrs{IP: alice.IP})
+	require.NoError(ss.T(), err)
+	_, err = srv.HandleBase64(js.Data, js.Name, alice)
+	require.Error(ss.T(), err)
+	assert.Equal(ss.T(), http.StatusInsufficientStorage, err.(*HTTPError).Status())
+
+	usage, err := srv.Usage(alice)
+	require.NoError(ss.T(), err)
+	meta, err := srv.Meta(*first)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), "192.0.2.1", meta.IP)
+	assert.Equal(ss.T(), &UsageStat{Files: 1, Bytes: meta.Size, MaxBytes: 1 << 20}, usage.ByOwner)
+	assert.Equal(ss.T(), &UsageStat{Files: 2, Bytes: 2 * meta.Size, MaxFiles: 2}, usage.ByIP)
+
+	// failed upload releases reservation, delete releases quota
+	_, err = srv.HandleBase64("data:image/png;base64,iVBORw0K", "bad.png", Attrs{IP: "192.0.2.2"})
+	require.Error(ss.T(), err)
+	require.NoError(ss.T(), srv.Delete(*first, alice))
+	usage, err = srv.Usage(Attrs{Owner: "alice", IP: "192.0.2.2"})
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 0, usage.ByOwner.Files)
+	assert.Equal(ss.T(), &UsageStat{MaxFiles: 2}, usage.ByIP)
+	_, err = srv.HandleBase64(js.Data, js.Name, alice)
+	assert.NoError(ss.T(), err)
+
+	// index is loaded from stored metadata
+	srv, err = New(cfg, ss.srv.Log)
+	require.NoError(ss.T(), err)
+	usage, err = srv.Usage(alice)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 2, usage.ByIP.Files)
+}
+
+func (ss *ServerSuite) TestQuotaURL() {
+	cfg := *ss.srv.Config
+	root := ss.T().TempDir()
+	cfg.Dir, cfg.PreviewDir, cfg.MetaDir = filepath.Join(root, "img"), filepath.Join(root, "preview"), filepath.Join(root, "meta")
+	cfg.QuotaSize = 1
+	srv, err := New(cfg, ss.srv.Log)
+	require.NoError(ss.T(), err)
+
+	// response without Content-Length
+	data, err := os.ReadFile("../testdata/build.png")
+	require.NoError(ss.T(), err)
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "image/png")
+		res.(http.Flusher).Flush()
+		res.Write(data) // nolint: errcheck
+	}))
+	defer testServer.Close()
+
+	alice := Attrs{Owner: "alice"}
+	index, err := srv.usage()
+	require.NoError(ss.T(), err)
+	index.add(alice.Owner, "", 1, 1<<20-int64(len(data))+1)
+	index.mu.Unlock()
+
+	_, err = srv.HandleURL(testServer.URL+"/build.png", alice)
+	require.Error(ss.T(), err)
+	assert.Equal(ss.T(), http.StatusRequestEntityTooLarge, err.(*HTTPError).Status())
+	assert.EqualError(ss.T(), err, ErrQuotaTooLarge)
+	usage, err := srv.Usage(alice)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 1, usage.ByOwner.Files, "reservation is released")
+}
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name   string
+		stat   UsageStat
+		size   int64
+		status int
+	}{
+		{"Unlimited", UsageStat{Files: 10, Bytes: 100}, 100, 0},
+		{"Fits", UsageStat{Files: 1, Bytes: 10, MaxFiles: 2, MaxBytes: 20}, 10, 0},
+		{"Files", UsageStat{Files: 2, MaxFiles: 2}, 1, http.StatusInsufficientStorage},
+		{"Full", UsageStat{Bytes: 20, MaxBytes: 20}, 0, http.StatusInsufficientStorage},
+		{"TooLarge", UsageStat{Bytes: 10, MaxBytes: 20}, 11, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		err := checkQuota(tt.stat, tt.size)
+		if tt.status == 0 {
+			assert.NoError(t, err, tt.name)
+			continue
+		}
+		require.Error(t, err, tt.name)
+		assert.Equal(t, tt.status, err.(*HTTPError).Status(), tt.name)
+	}
+}
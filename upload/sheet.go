@@ -0,0 +1,190 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/fs"
+	"math"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sunshineplan/imgconv"
+	"golang.org/x/image/font/basicfont"
+)
+
+const (
+	// ErrSheetTiles returned when contact sheet has no images or too many images
+	ErrSheetTiles = "contact sheet images count must be in range 1..%d"
+	// ErrSheetSize returned when contact sheet cell size or columns count is out of range
+	ErrSheetSize = "contact sheet cell size or columns count out of range"
+
+	// MaxSheetTiles holds max images count of contact sheet
+	MaxSheetTiles = 100
+	// MaxSheetCellSize holds max contact sheet cell size
+	MaxSheetCellSize = 512
+	// DefaultSheetCellSize holds contact sheet cell size used if not set in request
+	DefaultSheetCellSize = 128
+
+	// sheetCaptionHeight holds height of caption under contact sheet cell
+	sheetCaptionHeight = 16
+)
+
+// SheetRequest holds contact sheet request.
+// Images are taken from Names or, if Names is empty, from Limit newest stored images.
+type SheetRequest struct {
+	Names    []string `form:"name" json:"names"`
+	Limit    int      `form:"limit" json:"limit"`
+	Columns  int      `form:"columns" json:"columns"`
+	Size     int      `form:"size" json:"size"`
+	Captions bool     `form:"captions" json:"captions"`
+	Format   string   `form:"format" json:"format"`
+}
+
+// SheetTile holds image placement inside of contact sheet
+type SheetTile struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Sheet holds contact sheet image and its tiles placement by image name
+type Sheet struct {
+	Image  []byte               `json:"-"`
+	Format string               `json:"format"`
+	Width  int                  `json:"width"`
+	Height int                  `json:"height"`
+	Tiles  map[string]SheetTile `json:"tiles"`
+}
+
+// Sheet renders contact sheet (grid) of caller's stored images
+func (srv Service) Sheet(req SheetRequest, attrs Attrs) (*Sheet, error) {
+	if req.Size == 0 {
+		req.Size = DefaultSheetCellSize
+	}
+	if req.Format == "" {
+		req.Format = "png"
+	}
+	if req.Size < 1 || req.Size > MaxSheetCellSize || req.Columns < 0 || req.Columns > MaxSheetTiles {
+		return nil, NewHTTPError(http.StatusBadRequest, errors.New(ErrSheetSize))
+	}
+	format, err := iiifFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+	names := req.Names
+	if len(names) == 0 {
+		if names, err = srv.newest(min(req.Limit, MaxSheetTiles), attrs); err != nil {
+			return nil, err
+		}
+	}
+	if len(names) == 0 || len(names) > MaxSheetTiles {
+		return nil, NewHTTPError(http.StatusBadRequest, fmt.Errorf(ErrSheetTiles, MaxSheetTiles))
+	}
+	columns := req.Columns
+	if columns == 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(names)))))
+	}
+	columns = min(columns, len(names))
+	rows := (len(names) + columns - 1) / columns
+	cellHeight := req.Size
+	if req.Captions {
+		cellHeight += sheetCaptionHeight
+	}
+
+	rv := &Sheet{Format: req.Format, Width: columns * req.Size, Height: rows * cellHeight, Tiles: map[string]SheetTile{}}
+	sheet := image.NewNRGBA(image.Rect(0, 0, rv.Width, rv.Height))
+	if format == imgconv.JPEG {
+		// JPEG has no transparency
+		draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	for i, name := range names {
+		meta, err := srv.OwnedMeta(name, attrs)
+		if err != nil {
+			return nil, err
+		}
+		if meta.Private {
+			return nil, NewHTTPError(http.StatusNotFound, errors.New(ErrNotFound))
+		}
+		img, err := srv.sheetImage(meta, req.Size)
+		if err != nil {
+			return nil, err
+		}
+		// fit image into cell keeping aspect ratio
+		size := img.Bounds().Size()
+		scale := math.Min(float64(req.Size)/float64(max(size.X, size.Y)), 1)
+		size = image.Pt(max(int(float64(size.X)*scale), 1), max(int(float64(size.Y)*scale), 1))
+		img = imgconv.Resize(img, &imgconv.ResizeOption{Width: size.X, Height: size.Y})
+		cell := image.Pt(i%columns*req.Size, i/columns*cellHeight)
+		pt := cell.Add(image.Pt((req.Size-size.X)/2, (req.Size-size.Y)/2))
+		draw.Draw(sheet, image.Rectangle{pt, pt.Add(size)}, img, img.Bounds().Min, draw.Over)
+		rv.Tiles[name] = SheetTile{X: pt.X, Y: pt.Y, Width: size.X, Height: size.Y}
+		if req.Captions {
+			caption := textImage(shorten(name, req.Size/basicfont.Face7x13.Advance))
+			pt = cell.Add(image.Pt((req.Size-caption.Bounds().Dx())/2, req.Size))
+			draw.Draw(sheet, image.Rectangle{pt, pt.Add(caption.Bounds().Size())}, caption, image.Point{}, draw.Over)
+		}
+	}
+	var buf bytes.Buffer
+	if err = imgconv.Write(&buf, sheet, &imgconv.FormatOption{Format: format}); err != nil {
+		return nil, err
+	}
+	rv.Image = buf.Bytes()
+	return rv, nil
+}
+
+// sheetImage returns smallest srcset copy of image which covers cell size, so originals are not decoded.
+// Preview is used for images stored without srcset copies.
+func (srv Service) sheetImage(meta *Meta, size int) (image.Image, error) {
+	file := filepath.Join(srv.Config.PreviewDir, RasterName(meta.Name))
+	if widths := meta.SrcsetWidths; len(widths) > 0 {
+		width := widths[len(widths)-1]
+		if i := slices.IndexFunc(widths, func(w int) bool { return w >= size }); i >= 0 {
+			width = widths[i]
+		}
+		var err error
+		if file, err = srv.variant(meta.Name, nil, width, 0); err != nil {
+			return nil, err
+		}
+	}
+	img, err := imgconv.Open(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, NewHTTPError(http.StatusNotFound, errors.New(ErrNotFound))
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+// newest returns names of up to limit newest stored images of caller
+func (srv Service) newest(limit int, attrs Attrs) ([]string, error) {
+	list, err := srv.list()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(list, func(a, b Meta) int { return b.Created.Compare(a.Created) })
+	var rv []string
+	for i := 0; i < len(list) && len(rv) < limit; i++ {
+		if !list[i].Private && attrs.owns(&list[i]) {
+			rv = append(rv, list[i].Name)
+		}
+	}
+	return rv, nil
+}
+
+// shorten returns text cut to size chars, cut is marked by ellipsis
+func shorten(text string, size int) string {
+	text = strings.TrimPrefix(text, "/")
+	runes := []rune(text)
+	if len(runes) <= size || size < 2 {
+		return text
+	}
+	return string(runes[:size-2]) + ".."
+}
@@ -0,0 +1,68 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+func (ss *ServerSuite) TestSheet() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	other, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+
+	sheet, err := ss.srv.Sheet(SheetRequest{Names: []string{*name, *other}, Columns: 1, Size: 50, Captions: true}, Attrs{})
+	require.NoError(ss.T(), err)
+	img, err := imgconv.Decode(bytes.NewReader(sheet.Image))
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), image.Pt(50, 2*(50+sheetCaptionHeight)), img.Bounds().Size())
+	assert.Equal(ss.T(), image.Pt(sheet.Width, sheet.Height), img.Bounds().Size())
+	require.Equal(ss.T(), 2, len(sheet.Tiles))
+	tile := sheet.Tiles[*other]
+	assert.Equal(ss.T(), 50, max(tile.Width, tile.Height))
+	assert.GreaterOrEqual(ss.T(), tile.Y, 50+sheetCaptionHeight)
+
+	// listing query
+	sheet, err = ss.srv.Sheet(SheetRequest{Limit: 1, Format: "jpg"}, Attrs{})
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 1, len(sheet.Tiles))
+	assert.Contains(ss.T(), sheet.Tiles, *other, "newest image is used")
+
+	tests := []struct {
+		name   string
+		req    SheetRequest
+		status int
+	}{
+		{"NoImages", SheetRequest{}, http.StatusBadRequest},
+		{"BigSize", SheetRequest{Names: []string{*name}, Size: MaxSheetCellSize + 1}, http.StatusBadRequest},
+		{"BadColumns", SheetRequest{Names: []string{*name}, Columns: -1}, http.StatusBadRequest},
+		{"BadFormat", SheetRequest{Names: []string{*name}, Format: "bmp"}, http.StatusBadRequest},
+		{"TooMany", SheetRequest{Names: make([]string, MaxSheetTiles+1)}, http.StatusBadRequest},
+		{"NotFound", SheetRequest{Names: []string{*name, "/unknown.png"}}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		_, err := ss.srv.Sheet(tt.req, Attrs{})
+		require.NotNil(ss.T(), err, tt.name)
+		assert.Equal(ss.T(), tt.status, err.(*HTTPError).Status(), tt.name)
+	}
+
+	// sheet is built from srcset copies, original is not decoded
+	require.NoError(ss.T(), os.Remove(filepath.Join(ss.cfg.Dir, *name)))
+	_, err = ss.srv.Sheet(SheetRequest{Names: []string{*name}}, Attrs{})
+	assert.NoError(ss.T(), err)
+}
+
+func TestShorten(t *testing.T) {
+	assert.Equal(t, "file.png", shorten("/file.png", 10))
+	assert.Equal(t, "long-fi..", shorten("/long-filename.png", 9))
+}
@@ -0,0 +1,49 @@
+package upload
+
+import (
+	"image"
+	"os"
+	"slices"
+)
+
+// srcsetWidths returns widths of responsive image copies (or avatar sizes) for image of width and profile given.
+// Copies are never upscaled, so widths above image width are replaced by image width.
+func (srv Service) srcsetWidths(width int, profile string) []int {
+	limit := min(width, srv.Config.VariantMaxSize)
+	widths := srv.Config.SrcsetWidths
+	if profile == ProfileAvatar {
+		widths = srv.Config.AvatarSizes
+	}
+	var rv []int
+	for _, w := range widths {
+		if w <= 0 {
+			continue
+		}
+		rv = append(rv, min(w, limit))
+	}
+	slices.Sort(rv)
+	return slices.Compact(rv)
+}
+
+// buildSrcset creates responsive image copies from decoded image, so they are ready before first request.
+// Copies are removed on error.
+func (srv Service) buildSrcset(name string, img image.Image, widths []int) (files []string, err error) {
+	for _, w := range widths {
+		var file string
+		if file, err = srv.variant(name, img, w, 0); err != nil {
+			srv.removeVariants(files)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// removeVariants removes image copies
+func (srv Service) removeVariants(files []string) {
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			srv.Log.Errorf("Error removing variant: %v", err)
+		}
+	}
+}
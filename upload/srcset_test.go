@@ -0,0 +1,53 @@
+package upload
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (ss *ServerSuite) TestSrcset() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	require.NotEmpty(ss.T(), meta.SrcsetWidths)
+	for _, w := range meta.SrcsetWidths {
+		assert.LessOrEqual(ss.T(), w, meta.Width)
+		// copies are created on upload
+		_, err = os.Stat(filepath.Join(ss.cfg.VariantDir, fmt.Sprintf("%dx0", w), *name))
+		assert.NoError(ss.T(), err)
+	}
+}
+
+func (ss *ServerSuite) TestSrcsetCleanup() {
+	// file in place of copy dir breaks second copy creation
+	broken := filepath.Join(ss.cfg.VariantDir, "21x0")
+	require.NoError(ss.T(), os.MkdirAll(ss.cfg.VariantDir, 0750))
+	require.NoError(ss.T(), os.WriteFile(broken, nil, 0600))
+	defer os.Remove(broken)
+
+	name := "/srcset-cleanup.png"
+	_, err := ss.srv.buildSrcset(name, image.NewGray(image.Rect(0, 0, 40, 40)), []int{11, 21})
+	require.Error(ss.T(), err)
+	_, err = os.Stat(filepath.Join(ss.cfg.VariantDir, "11x0", name))
+	assert.True(ss.T(), os.IsNotExist(err))
+}
+
+func TestSrcsetWidths(t *testing.T) {
+	srv := Service{Config: &Config{SrcsetWidths: []int{640, 0, 320, 1024, 320}, VariantMaxSize: 800}}
+	assert.Equal(t, []int{320, 640, 800}, srv.srcsetWidths(2000, ""))
+	assert.Equal(t, []int{320, 500}, srv.srcsetWidths(500, ""))
+	assert.Equal(t, []int{100}, srv.srcsetWidths(100, ""))
+	srv.Config.AvatarSizes = []int{32, 64}
+	assert.Equal(t, []int{32, 64}, srv.srcsetWidths(100, ProfileAvatar))
+	srv.Config.SrcsetWidths = nil
+	assert.Empty(t, srv.srcsetWidths(100, ""))
+}
@@ -0,0 +1,269 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// SVGExt holds SVG image file extension
+	SVGExt = ".svg"
+	// RasterExt holds extension of raster copies of images which format can not be written
+	RasterExt = ".png"
+
+	// ErrSVGSize returned when SVG image has no size
+	ErrSVGSize = "svg image size is not defined"
+)
+
+// svgElements holds allowed SVG elements (lowercase), other elements are removed with all its content.
+// Scripts, foreign objects and animations (which can set any attribute) are not allowed.
+var svgElements = setOf(
+	"svg", "g", "defs", "symbol", "use", "switch", "view", "title", "desc", "style", "a",
+	"path", "rect", "circle", "ellipse", "line", "polyline", "polygon", "image",
+	"text", "tspan", "textpath",
+	"lineargradient", "radialgradient", "stop", "pattern", "clippath", "mask", "marker",
+	"filter", "feblend", "fecolormatrix", "fecomponenttransfer", "fecomposite", "feconvolvematrix",
+	"fediffuselighting", "fedisplacementmap", "fedistantlight", "fedropshadow", "feflood",
+	"fefunca", "fefuncb", "fefuncg", "fefuncr", "fegaussianblur", "feimage", "femerge",
+	"femergenode", "femorphology", "feoffset", "fepointlight", "fespecularlighting",
+	"fespotlight", "fetile", "feturbulence",
+)
+
+// svgAttrs holds allowed SVG attributes without namespace (lowercase)
+var svgAttrs = setOf(
+	"id", "class", "style", "version", "viewbox", "preserveaspectratio", "transform", "href",
+	"x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "fx", "fy", "fr",
+	"width", "height", "d", "points", "pathlength", "dx", "dy", "rotate", "textlength", "lengthadjust",
+	"fill", "fill-opacity", "fill-rule", "stroke", "stroke-width", "stroke-linecap", "stroke-linejoin",
+	"stroke-miterlimit", "stroke-dasharray", "stroke-dashoffset", "stroke-opacity", "opacity",
+	"color", "display", "visibility", "overflow", "clip", "clip-path", "clip-rule", "mask", "filter",
+	"paint-order", "vector-effect", "shape-rendering", "text-rendering", "image-rendering",
+	"color-interpolation", "color-interpolation-filters", "mix-blend-mode", "isolation",
+	"font", "font-family", "font-size", "font-weight", "font-style", "font-variant", "font-stretch",
+	"text-anchor", "dominant-baseline", "alignment-baseline", "baseline-shift", "letter-spacing",
+	"word-spacing", "text-decoration", "writing-mode", "direction", "unicode-bidi",
+	"offset", "stop-color", "stop-opacity", "gradientunits", "gradienttransform", "spreadmethod",
+	"patternunits", "patterncontentunits", "patterntransform", "clippathunits", "maskunits",
+	"maskcontentunits", "markerwidth", "markerheight", "markerunits", "refx", "refy", "orient",
+	"marker", "marker-start", "marker-mid", "marker-end",
+	"filterunits", "primitiveunits", "in", "in2", "result", "stddeviation", "mode", "operator",
+	"k1", "k2", "k3", "k4", "type", "values", "tablevalues", "slope", "intercept", "amplitude",
+	"exponent", "flood-color", "flood-opacity", "lighting-color", "surfacescale", "diffuseconstant",
+	"specularconstant", "specularexponent", "kernelmatrix", "kernelunitlength", "order", "divisor",
+	"bias", "targetx", "targety", "edgemode", "preservealpha", "radius", "scale",
+	"xchannelselector", "ychannelselector", "basefrequency", "numoctaves", "seed", "stitchtiles",
+	"azimuth", "elevation", "z", "pointsatx", "pointsaty", "pointsatz", "limitingconeangle",
+)
+
+// svgNSAttrs holds allowed SVG attributes with namespace prefix, all namespace declarations are allowed
+var svgNSAttrs = setOf("xlink:href", "xlink:title", "xml:space", "xml:lang")
+
+// svgSchemes holds URL schemes of scripts
+var svgSchemes = []string{"javascript:", "vbscript:", "data:text/html"}
+
+// setOf returns set of items given
+func setOf(items ...string) map[string]bool {
+	rv := make(map[string]bool, len(items))
+	for _, item := range items {
+		rv[item] = true
+	}
+	return rv
+}
+
+// RasterName returns name of stored image raster copy (preview or variant).
+// Copies of images which format can not be written (SVG, ICO) are stored as PNG.
+func RasterName(name string) string {
+	if _, err := imgconv.FormatFromExtension(strings.TrimPrefix(path.Ext(name), ".")); err != nil {
+		return name + RasterExt
+	}
+	return name
+}
+
+// isSVG checks if file is SVG image by its extension
+func isSVG(name string) bool {
+	return strings.EqualFold(path.Ext(name), SVGExt)
+}
+
+// openImage decodes image file. SVG is rasterized, animated WebP is decoded by first frame,
+// multi-page image is decoded by preview page, colors with ICC profile are converted to sRGB.
+func (srv Service) openImage(file string) (image.Image, error) {
+	if srv.Config.SVG && isSVG(file) {
+		data, err := os.ReadFile(file) // #nosec G304, file is stored image
+		if err != nil {
+			return nil, err
+		}
+		return rasterizeSVG(data, srv.Config.VariantMaxSize)
+	}
+	if hasPages(file) {
+		img, err := decodePage(file, srv.Config.PreviewPage)
+		if err != nil && err.Error() == ErrPageNotFound {
+			// image has less pages than configured
+			img, err = decodePage(file, 1)
+		}
+		return img, err
+	}
+	img, err := imgconv.Open(file)
+	if err != nil {
+		// animated WebP is not supported by decoder, use its first frame
+		var e error
+		if img, e = decodeWebPFrame(file); e != nil {
+			return nil, err
+		}
+	}
+	if profile := srv.iccOf(file); profile != nil && !profile.isSRGB() {
+		img = profile.convert(img)
+	}
+	return img, nil
+}
+
+// sanitizeSVG copies SVG from src to dst with allowed elements and attributes only,
+// so scripts, event handlers, animations, external references and foreign objects are removed.
+// Returns count of written bytes.
+func sanitizeSVG(dst io.Writer, src io.Reader) (int64, error) {
+	var buf bytes.Buffer
+	dec := xml.NewDecoder(src)
+	enc := xml.NewEncoder(&buf)
+	skip := 0 // depth inside of forbidden element
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 || !svgElements[strings.ToLower(t.Name.Local)] {
+				skip++
+				continue
+			}
+			t.Name = flatName(t.Name)
+			attrs := t.Attr[:0]
+			for _, a := range t.Attr {
+				if safeSVGAttr(a) {
+					a.Name = flatName(a.Name)
+					attrs = append(attrs, a)
+				}
+			}
+			t.Attr = attrs
+			tok = t
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			t.Name = flatName(t.Name)
+			tok = t
+		case xml.CharData:
+			if skip > 0 || hasExternalRef(string(t)) {
+				continue
+			}
+		case xml.ProcInst:
+			if skip > 0 || t.Target != "xml" {
+				continue
+			}
+		default:
+			// comments and directives (DOCTYPE with entities) are dropped
+			continue
+		}
+		if err = enc.EncodeToken(xml.CopyToken(tok)); err != nil {
+			return 0, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return 0, err
+	}
+	return io.Copy(dst, &buf)
+}
+
+// safeSVGAttr checks if SVG attribute is allowed and has no script or external reference
+func safeSVGAttr(a xml.Attr) bool {
+	name := strings.ToLower(a.Name.Local)
+	switch space := strings.ToLower(a.Name.Space); {
+	case space == "xmlns" || (space == "" && name == "xmlns"):
+		return true
+	case space == "" && !svgAttrs[name], space != "" && !svgNSAttrs[space+":"+name]:
+		return false
+	}
+	value := normalizeSVGValue(a.Value)
+	if name == "href" {
+		return strings.HasPrefix(value, "#")
+	}
+	for _, scheme := range svgSchemes {
+		if strings.Contains(value, scheme) {
+			return false
+		}
+	}
+	return !hasExternalRef(value)
+}
+
+// normalizeSVGValue returns lowercase attribute value without whitespace and control characters,
+// which browsers ignore in URL schemes
+func normalizeSVGValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, value)
+}
+
+// hasExternalRef checks if CSS text contains import or url() not pointing to document fragment
+func hasExternalRef(text string) bool {
+	text = strings.ToLower(text)
+	if strings.Contains(text, "@import") {
+		return true
+	}
+	for {
+		_, after, ok := strings.Cut(text, "url(")
+		if !ok {
+			return false
+		}
+		if !strings.HasPrefix(strings.TrimLeft(after, ` '"`), "#") {
+			return true
+		}
+		text = after
+	}
+}
+
+// flatName keeps namespace prefix as is, because encoder treats it as namespace URL
+func flatName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+// rasterizeSVG renders SVG image at its own size limited by maxSize
+func rasterizeSVG(data []byte, maxSize int) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	w, h := icon.ViewBox.W, icon.ViewBox.H
+	if w <= 0 || h <= 0 {
+		return nil, errors.New(ErrSVGSize)
+	}
+	if scale := float64(maxSize) / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	width, height := max(int(math.Round(w)), 1), max(int(math.Round(h)), 1)
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
@@ -0,0 +1,125 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+const testSVG = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE svg [<!ENTITY x SYSTEM "file:///etc/passwd">]>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="120" height="60">
+  <script>alert(1)</script>
+  <style>@import url(https://example.com/evil.css);</style>
+  <defs><linearGradient id="g"><stop offset="0" stop-color="red"/></linearGradient></defs>
+  <rect id="r" width="120" height="60" fill="url(#g)" onload="alert(2)"/>
+  <use xlink:href="#r"/>
+  <image href="https://example.com/track.png" width="1" height="1"/>
+  <foreignObject><div xmlns="http://www.w3.org/1999/xhtml">html</div></foreignObject>
+  <a xlink:href="javascript:alert(3)"><circle cx="30" cy="30" r="20" fill="blue"/></a>
+</svg>`
+
+func (ss *ServerSuite) TestSVG() {
+	data := "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(testSVG))
+
+	// SVG is not accepted by default
+	_, err := ss.srv.HandleBase64(data, "logo.svg", Attrs{})
+	require.NotNil(ss.T(), err)
+	assert.Equal(ss.T(), http.StatusUnsupportedMediaType, err.(*HTTPError).Status())
+
+	ss.srv.Config.SVG = true
+	defer func() { ss.srv.Config.SVG = ss.cfg.SVG }()
+	name, err := ss.srv.HandleBase64(data, "logo.svg", Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 120, meta.Width)
+	assert.Equal(ss.T(), 60, meta.Height)
+
+	stored, err := os.ReadFile(filepath.Join(ss.cfg.Dir, *name))
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), meta.Size, int64(len(stored)))
+	assert.NotContains(ss.T(), string(stored), "alert")
+
+	preview, err := imgconv.Open(filepath.Join(ss.cfg.PreviewDir, RasterName(*name)))
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), ss.cfg.PreviewWidth, preview.Bounds().Dx())
+
+	file, err := ss.srv.Variant(*name, 60, 0)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), RasterExt, filepath.Ext(file))
+
+	_, err = ss.srv.Edit(*name, []Operation{{Op: OpGrayscale}}, Attrs{})
+	require.NotNil(ss.T(), err)
+	assert.Equal(ss.T(), http.StatusUnsupportedMediaType, err.(*HTTPError).Status())
+
+	// broken XML
+	data = "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte("<svg><g></svg>"))
+	_, err = ss.srv.HandleBase64(data, "broken.svg", Attrs{})
+	require.NotNil(ss.T(), err)
+	assert.Equal(ss.T(), http.StatusUnsupportedMediaType, err.(*HTTPError).Status())
+}
+
+func TestSanitizeSVG(t *testing.T) {
+	var buf bytes.Buffer
+	cnt, err := sanitizeSVG(&buf, strings.NewReader(testSVG))
+	require.NoError(t, err)
+	out := buf.String()
+	assert.Equal(t, int64(len(out)), cnt)
+	for _, s := range []string{"<script", "alert", "@import", "example.com", "foreignObject", "html", "onload", "ENTITY", "passwd"} {
+		assert.NotContains(t, out, s)
+	}
+	for _, s := range []string{`xmlns:xlink="http://www.w3.org/1999/xlink"`, `<use xlink:href="#r">`, `fill="url(#g)"`, "<circle"} {
+		assert.Contains(t, out, s)
+	}
+	_, err = imgconv.Decode(strings.NewReader(out))
+	assert.NotNil(t, err, "SVG is not decoded by imgconv")
+	img, err := rasterizeSVG(buf.Bytes(), 60)
+	require.NoError(t, err)
+	assert.Equal(t, []int{60, 30}, []int{img.Bounds().Dx(), img.Bounds().Dy()})
+
+	_, err = rasterizeSVG([]byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), 60)
+	assert.EqualError(t, err, ErrSVGSize)
+}
+
+func TestSanitizeSVGBypass(t *testing.T) {
+	tests := []struct {
+		name string
+		svg  string
+	}{
+		{"Animate", `<svg><a><animate attributeName="href" values="java&#x9;script:alert(1)"/><circle r="5"/></a></svg>`},
+		{"Set", `<svg><a><set attributeName="xlink:href" to="javascript:alert(1)"/><circle r="5"/></a></svg>`},
+		{"EncodedScheme", `<svg><rect fill="url(java&#x0A;script:alert(1))" width="1"/></svg>`},
+		{"SplitScheme", `<svg><rect filter="JaVa&#x0D;Script:alert(1)" width="1"/></svg>`},
+		{"UnknownAttr", `<svg><rect width="1" formaction="alert(1)"/></svg>`},
+		{"NSAttr", `<svg><rect width="1" ev:event="alert(1)"/></svg>`},
+		{"UnknownElement", `<svg><discard><circle onclick="" r="5"/>alert(1)</discard></svg>`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		_, err := sanitizeSVG(&buf, strings.NewReader(tt.svg))
+		require.NoError(t, err, tt.name)
+		out := strings.ToLower(buf.String())
+		for _, s := range []string{"alert", "animate", "<set", "script"} {
+			assert.NotContains(t, out, s, tt.name)
+		}
+	}
+}
+
+func TestNormalizeSVGValue(t *testing.T) {
+	assert.Equal(t, "javascript:alert(1)", normalizeSVGValue(" Java\tScript\x00:\nalert(1)"))
+}
+
+func TestRasterName(t *testing.T) {
+	assert.Equal(t, "/logo.svg.png", RasterName("/logo.svg"))
+	assert.Equal(t, "/logo.SVG.png", RasterName("/logo.SVG"))
+	assert.Equal(t, "/pic.jpg", RasterName("/pic.jpg"))
+}
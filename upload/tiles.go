@@ -0,0 +1,168 @@
+package upload
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// TileDescriptorExt holds Deep Zoom descriptor file extension
+	TileDescriptorExt = ".dzi"
+	// TileFilesSuffix holds suffix of Deep Zoom tiles dir
+	TileFilesSuffix = "_files"
+
+	// DZINamespace holds Deep Zoom descriptor XML namespace
+	DZINamespace = "http://schemas.microsoft.com/deepzoom/2008"
+
+	// ErrTileSize returned by New when tile size is not positive
+	ErrTileSize = "tile size must be positive"
+	// ErrTileOverlap returned by New when tile overlap is out of range
+	ErrTileOverlap = "tile overlap must be in range 0..tile size-1"
+)
+
+// DZI holds Deep Zoom Image descriptor
+type DZI struct {
+	XMLName  xml.Name `xml:"Image"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	TileSize int      `xml:"TileSize,attr"`
+	Overlap  int      `xml:"Overlap,attr"`
+	Format   string   `xml:"Format,attr"`
+	Size     struct {
+		Width  int `xml:"Width,attr"`
+		Height int `xml:"Height,attr"`
+	} `xml:"Size"`
+}
+
+// checkTileConfig checks tile pyramid config, tile loops never end if tile size is not positive
+func checkTileConfig(cfg *Config) error {
+	if cfg.TileSize <= 0 {
+		return errors.New(ErrTileSize)
+	}
+	if cfg.TileOverlap < 0 || cfg.TileOverlap >= cfg.TileSize {
+		return errors.New(ErrTileOverlap)
+	}
+	return nil
+}
+
+// useTiles checks if tile pyramid should be built for image
+func (srv Service) useTiles(img image.Image) bool {
+	size := srv.Config.TileMinSize
+	return size > 0 && srv.tiles != nil && (img.Bounds().Dx() >= size || img.Bounds().Dy() >= size)
+}
+
+// buildTilesAsync builds tile pyramid in background.
+// Call waits while TileWorkers builds are running, so decoded images do not pile up in memory.
+func (srv Service) buildTilesAsync(name string, img image.Image) {
+	srv.tileJobs <- struct{}{}
+	srv.tiles.Add(1)
+	go func() {
+		defer func() {
+			<-srv.tileJobs
+			srv.tiles.Done()
+		}()
+		if err := srv.buildTiles(name, img); err != nil {
+			srv.Log.Errorf("Tiles of %s build error: %v", name, err)
+			return
+		}
+		srv.Log.Infof("Tiles of %s built", name)
+	}()
+}
+
+// Wait waits for background tile builds to finish
+func (srv Service) Wait() {
+	if srv.tiles != nil {
+		srv.tiles.Wait()
+	}
+}
+
+// buildTiles builds Deep Zoom tile pyramid for image.
+// Descriptor is written last, so it points to complete pyramid only.
+func (srv Service) buildTiles(name string, img image.Image) error {
+	cfg := srv.Config
+	base := filepath.Join(cfg.TileDir, path.Clean("/"+name))
+	if err := os.MkdirAll(filepath.Dir(base), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(base), ".tiles-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp) // nolint: errcheck
+
+	b := img.Bounds()
+	for level := tileMaxLevel(b.Dx(), b.Dy()); level >= 0; level-- {
+		if err = srv.writeLevel(filepath.Join(tmp, fmt.Sprint(level)), img); err != nil {
+			return err
+		}
+		if level > 0 {
+			w, h := img.Bounds().Dx(), img.Bounds().Dy()
+			img = imgconv.Resize(img, &imgconv.ResizeOption{Width: (w + 1) / 2, Height: (h + 1) / 2})
+		}
+	}
+	files := base + TileFilesSuffix
+	if err = os.RemoveAll(files); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, files); err != nil {
+		return err
+	}
+
+	dzi := DZI{XMLNS: DZINamespace, TileSize: cfg.TileSize, Overlap: cfg.TileOverlap, Format: cfg.TileFormat}
+	dzi.Size.Width, dzi.Size.Height = b.Dx(), b.Dy()
+	data, err := xml.MarshalIndent(dzi, "", "  ")
+	if err != nil {
+		return err
+	}
+	descriptor := base + TileDescriptorExt
+	if err = os.WriteFile(descriptor+".tmp", append([]byte(xml.Header), data...), 0600); err != nil {
+		return err
+	}
+	return os.Rename(descriptor+".tmp", descriptor)
+}
+
+// writeLevel saves tiles of pyramid level image into dir
+func (srv Service) writeLevel(dir string, img image.Image) error {
+	cfg := srv.Config
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	b := img.Bounds()
+	for row := 0; row*cfg.TileSize < b.Dy(); row++ {
+		for col := 0; col*cfg.TileSize < b.Dx(); col++ {
+			rect := tileRect(col, row, cfg.TileSize, cfg.TileOverlap).Add(b.Min).Intersect(b)
+			tile := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+			draw.Draw(tile, tile.Bounds(), img, rect.Min, draw.Src)
+			file := filepath.Join(dir, fmt.Sprintf("%d_%d.%s", col, row, cfg.TileFormat))
+			if err := writeImage(file, tile, srv.encodeOptions(PresetTile)...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tileMaxLevel returns level number of full size image in pyramid, level 0 is 1x1 image
+func tileMaxLevel(width, height int) int {
+	return int(math.Ceil(math.Log2(float64(max(width, height)))))
+}
+
+// tileRect returns tile rectangle including overlap with neighbours
+func tileRect(col, row, size, overlap int) image.Rectangle {
+	rect := image.Rect(col*size, row*size, (col+1)*size+overlap, (row+1)*size+overlap)
+	if col > 0 {
+		rect.Min.X -= overlap
+	}
+	if row > 0 {
+		rect.Min.Y -= overlap
+	}
+	return rect
+}
@@ -0,0 +1,97 @@
+package upload
+
+import (
+	"encoding/xml"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+func (ss *ServerSuite) TestTiles() {
+	ss.srv.Config.TileMinSize = 1
+	ss.srv.Config.TileSize = 64
+	defer func() {
+		ss.srv.Config.TileMinSize = ss.cfg.TileMinSize
+		ss.srv.Config.TileSize = ss.cfg.TileSize
+	}()
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	ss.srv.tiles.Wait()
+
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.True(ss.T(), meta.Tiles)
+
+	base := filepath.Join(ss.cfg.TileDir, *name)
+	data, err := os.ReadFile(base + TileDescriptorExt)
+	require.NoError(ss.T(), err)
+	dzi := DZI{}
+	require.NoError(ss.T(), xml.Unmarshal(data, &dzi))
+	assert.Equal(ss.T(), meta.Width, dzi.Size.Width)
+	assert.Equal(ss.T(), meta.Height, dzi.Size.Height)
+	assert.Equal(ss.T(), "jpg", dzi.Format)
+
+	levels, err := os.ReadDir(base + TileFilesSuffix)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), tileMaxLevel(meta.Width, meta.Height)+1, len(levels))
+	img, err := imgconv.Open(filepath.Join(base+TileFilesSuffix, "0", "0_0.jpg"))
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), image.Pt(1, 1), img.Bounds().Size())
+	img, err = imgconv.Open(filepath.Join(base+TileFilesSuffix, "8", "1_1.jpg"))
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 64+2, img.Bounds().Dx())
+
+	// small images are not tiled
+	ss.srv.Config.TileMinSize = meta.Width + 1
+	ss.srv.Config.UseRandomName = true
+	defer func() { ss.srv.Config.UseRandomName = ss.cfg.UseRandomName }()
+	name, err = ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err = ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.False(ss.T(), meta.Tiles)
+}
+
+func TestCheckTileConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		size, overlap int
+		err           string
+	}{
+		{"OK", 254, 1, ""},
+		{"ZeroSize", 0, 0, ErrTileSize},
+		{"NegativeSize", -1, 0, ErrTileSize},
+		{"NegativeOverlap", 254, -1, ErrTileOverlap},
+		{"BigOverlap", 4, 4, ErrTileOverlap},
+	}
+	for _, tt := range tests {
+		err := checkTileConfig(&Config{TileSize: tt.size, TileOverlap: tt.overlap})
+		if tt.err == "" {
+			assert.NoError(t, err, tt.name)
+			continue
+		}
+		assert.EqualError(t, err, tt.err, tt.name)
+	}
+	_, err := New(Config{TileSize: 0}, nil)
+	assert.EqualError(t, err, ErrTileSize)
+}
+
+func TestTileMaxLevel(t *testing.T) {
+	assert.Equal(t, 0, tileMaxLevel(1, 1))
+	assert.Equal(t, 1, tileMaxLevel(2, 1))
+	assert.Equal(t, 8, tileMaxLevel(200, 256))
+	assert.Equal(t, 9, tileMaxLevel(257, 10))
+}
+
+func TestTileRect(t *testing.T) {
+	assert.Equal(t, image.Rect(0, 0, 255, 255), tileRect(0, 0, 254, 1))
+	assert.Equal(t, image.Rect(253, 0, 509, 255), tileRect(1, 0, 254, 1))
+	assert.Equal(t, image.Rect(253, 507, 509, 763), tileRect(1, 2, 254, 1))
+}
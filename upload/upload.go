@@ -3,7 +3,9 @@ package upload
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
@@ -17,6 +19,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sunshineplan/imgconv"
 	"gopkg.in/birkirb/loggers.v1"
@@ -26,13 +30,60 @@ import (
 
 // Config holds all config vars
 type Config struct {
-	DownloadLimit     int64    `long:"download_limit" default:"8" description:"External image size limit (Mb)"`
-	Dir               string   `long:"dir" default:"data/img" description:"Image upload destination"`
-	PreviewDir        string   `long:"preview_dir" default:"data/preview" description:"Preview image destination"`
-	PreviewWidth      int      `long:"preview_width" default:"100" description:"Preview image width"`
-	PreviewHeight     int      `long:"preview_heigth" default:"100" description:"Preview image heigth"`
-	UseRandomName     bool     `long:"random_name" description:"Do not keep uploaded image filename"`
-	AllowedImageHosts []string `long:"image_host" description:"Hostnames allowed to fetch images from"`
+	DownloadLimit      int64    `long:"download_limit" default:"8" description:"External image size limit (Mb)"`
+	Dir                string   `long:"dir" default:"data/img" description:"Image upload destination"`
+	PreviewDir         string   `long:"preview_dir" default:"data/preview" description:"Preview image destination"`
+	PreviewWidth       int      `long:"preview_width" default:"100" description:"Preview image width"`
+	PreviewHeight      int      `long:"preview_heigth" default:"100" description:"Preview image heigth"`
+	PreviewQuality     int      `long:"preview_quality" default:"75" description:"Preview JPEG quality (1-100)"`
+	PreviewCompression string   `long:"preview_compression" default:"default" choice:"default" choice:"none" choice:"speed" choice:"best" description:"Preview PNG compression level"`
+	PreviewPage        int      `long:"preview_page" default:"1" description:"Page of multi-page image (TIFF, ICO) used for preview"`
+	MetaDir            string   `long:"meta_dir" default:"data/meta" description:"Image metadata destination"`
+	LQIPWidth          int      `long:"lqip_width" default:"16" description:"Low quality image placeholder width"`
+	BlurHashX          int      `long:"blurhash_x" default:"4" description:"BlurHash horizontal components count"`
+	BlurHashY          int      `long:"blurhash_y" default:"3" description:"BlurHash vertical components count"`
+	PaletteSize        int      `long:"palette_size" default:"5" description:"Image palette colors count"`
+	SimilarDistance    int      `long:"similar_distance" default:"10" description:"Default Hamming distance for similar images search"`
+	Animation          string   `long:"animation" default:"static" choice:"static" choice:"animated" description:"Animated image preview policy"`
+	MaxFrames          int      `long:"max_frames" default:"100" description:"Max frames count of animated preview"`
+	VariantDir         string   `long:"variant_dir" default:"data/variant" description:"On-demand image variants cache"`
+	VariantMaxSize     int      `long:"variant_max_size" default:"2048" description:"Max width and height of on-demand image variant"`
+	VariantQuality     int      `long:"variant_quality" default:"75" description:"Image variant JPEG quality (1-100)"`
+	VariantCompression string   `long:"variant_compression" default:"default" choice:"default" choice:"none" choice:"speed" choice:"best" description:"Image variant PNG compression level"`
+	SrcsetWidths       []int    `long:"srcset_width" default:"320" default:"640" default:"1024" default:"1920" description:"Responsive image copy widths (srcset)"`
+	Watermark          string   `long:"watermark" description:"Watermark image file"`
+	WatermarkText      string   `long:"watermark_text" description:"Watermark text, used if watermark file is not set"`
+	WatermarkPosition  string   `long:"watermark_position" default:"bottom-right" choice:"center" choice:"top-left" choice:"top-right" choice:"bottom-left" choice:"bottom-right" description:"Watermark position"`
+	WatermarkOpacity   int      `long:"watermark_opacity" default:"50" description:"Watermark opacity (%)"`
+	WatermarkScale     float64  `long:"watermark_scale" default:"0.25" description:"Watermark width relative to image width"`
+	WatermarkOn        []string `long:"watermark_on" default:"preview" default:"variant" choice:"preview" choice:"variant" description:"Image kinds to apply watermark to"`
+	TileDir            string   `long:"tile_dir" default:"data/tiles" description:"Deep zoom tile pyramids destination"`
+	TileMinSize        int      `long:"tile_min_size" default:"0" description:"Min image width or height to build tile pyramid for (0 - disabled)"`
+	TileSize           int      `long:"tile_size" default:"254" description:"Deep zoom tile size"`
+	TileOverlap        int      `long:"tile_overlap" default:"1" description:"Deep zoom tile overlap"`
+	TileFormat         string   `long:"tile_format" default:"jpg" choice:"jpg" choice:"png" description:"Deep zoom tile format"`
+	TileQuality        int      `long:"tile_quality" default:"75" description:"Deep zoom tile JPEG quality (1-100)"`
+	TileCompression    string   `long:"tile_compression" default:"default" choice:"default" choice:"none" choice:"speed" choice:"best" description:"Deep zoom tile PNG compression level"`
+	TileWorkers        int      `long:"tile_workers" default:"2" description:"Max concurrent tile pyramid builds"`
+	ColorProfile       string   `long:"color_profile" default:"srgb" choice:"srgb" choice:"ignore" description:"Embedded ICC profile handling"`
+	ICCOriginal        string   `long:"icc_original" default:"keep" choice:"keep" choice:"convert" description:"Keep original with ICC profile or store it converted to sRGB"`
+	Profile            string   `long:"profile" default:"default" choice:"default" choice:"avatar" description:"Upload processing profile"`
+	AvatarSizes        []int    `long:"avatar_size" default:"32" default:"64" default:"128" default:"256" description:"Avatar copy sizes"`
+	AvatarCircle       bool     `long:"avatar_circle" description:"Apply circular mask to avatars (PNG output)"`
+	SVG                bool     `long:"svg" description:"Accept SVG images (sanitized, with raster previews)"`
+	QuotaFiles         int      `long:"quota_files" default:"0" description:"Max images count per owner (0 - unlimited)"`
+	QuotaSize          int64    `long:"quota_size" default:"0" description:"Max images size per owner (Mb, 0 - unlimited)"`
+	IPQuotaFiles       int      `long:"ip_quota_files" default:"0" description:"Max images count per client IP (0 - unlimited)"`
+	IPQuotaSize        int64    `long:"ip_quota_size" default:"0" description:"Max images size per client IP (Mb, 0 - unlimited)"`
+	UseRandomName      bool     `long:"random_name" description:"Do not keep uploaded image filename"`
+	AllowedImageHosts  []string `long:"image_host" description:"Hostnames allowed to fetch images from"`
+
+	ClamdAddr     string        `long:"clamd_addr" description:"clamd address (host:port or unix socket path) to scan uploads with, disabled if empty"`
+	ClamdTimeout  time.Duration `long:"clamd_timeout" default:"30s" description:"clamd scan timeout"`
+	ClamdFailOpen bool          `long:"clamd_fail_open" description:"Accept unscanned uploads if clamd is not available"`
+
+	VariantSizes     []string `long:"variant_size" description:"Allowed on-demand variant size ({width}x{height}) in addition to image srcset widths"`
+	VariantCacheSize int64    `long:"variant_cache_size" default:"1024" description:"Max on-demand variants cache size (Mb, 0 - unlimited)"`
 }
 
 // codebeat:enable[TOO_MANY_IVARS]
@@ -65,22 +116,77 @@ const (
 	HostSchemeHTTP = "http"
 )
 
-var ReImageFileName = regexp.MustCompile(`^[\w][\w\s-]+\.[A-Za-z]{3}$`)
+var ReImageFileName = regexp.MustCompile(`^[\w][\w\s-]+\.[A-Za-z]{3,4}$`)
 
 // Service holds upload service
 type Service struct {
 	Config   *Config
 	Log      loggers.Contextual
-	getLimit int64 // store result of bytes to Mb calc
+	getLimit int64      // store result of bytes to Mb calc
+	mark     *watermark // nil if watermark is not configured
+	tiles    *sync.WaitGroup
+	tileJobs chan struct{} // tile builds semaphore
+
+	usageIndex   *usageIndex   // storage usage by owner and IP
+	variantCache *variantCache // on-demand variants cache size
+}
+
+// Attrs holds attributes of upload request stored in image metadata
+type Attrs struct {
+	KeyID   string // ID of API key used for request
+	Owner   string // ID of authenticated user, images are stored in its namespace
+	Admin   bool   // caller has access to images of all owners
+	IP      string // client IP
+	Private bool   // image is served by signed links only
+	Profile string // upload processing profile, Config.Profile if empty
+
+	Constraints *Constraints // limits of presigned upload, not stored
+}
+
+// owns checks if caller has access to image
+func (a Attrs) owns(meta *Meta) bool {
+	return a.Admin || meta.Owner == a.Owner
+}
+
+// meta returns metadata prefilled with request attributes
+func (a Attrs) meta() *Meta {
+	return &Meta{KeyID: a.KeyID, Owner: a.Owner, IP: a.IP, Private: a.Private, Profile: a.Profile, constraints: a.Constraints}
+}
+
+// reOwnerDir holds mask of owner ID which can be used as dir name as is
+var reOwnerDir = regexp.MustCompile(`^[A-Za-z0-9][\w.@-]{0,63}$`)
+
+// reOwnerKind holds mask of owner ID kind prefix (like "key" or "user")
+var reOwnerKind = regexp.MustCompile(`^[a-z]{1,16}$`)
+
+// OwnerDir returns storage dir of owner images, empty for anonymous owner.
+// Owner IDs unsafe for filesystem are replaced by hash.
+func OwnerDir(owner string) string {
+	if owner == "" || reOwnerDir.MatchString(owner) {
+		return owner
+	}
+	// owner ID of kind "key:<id>" or "user:<id>" is stored in subdir of kind
+	if kind, id, ok := strings.Cut(owner, ":"); ok && reOwnerKind.MatchString(kind) && reOwnerDir.MatchString(id) {
+		return kind + "/" + id
+	}
+	hash := sha256.Sum256([]byte(owner))
+	return "u" + hex.EncodeToString(hash[:16])
 }
 
-// New creates an Service object
-func New(cfg Config, log loggers.Contextual) *Service {
-	return &Service{&cfg, log, cfg.DownloadLimit << 20}
+// New creates an Service object, it fails if tile config is wrong or watermark can not be loaded
+func New(cfg Config, log loggers.Contextual) (*Service, error) {
+	if err := checkTileConfig(&cfg); err != nil {
+		return nil, err
+	}
+	mark, err := newWatermark(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{&cfg, log, cfg.DownloadLimit << 20, mark, &sync.WaitGroup{}, make(chan struct{}, max(cfg.TileWorkers, 1)), newUsageIndex(), &variantCache{}}, nil
 }
 
 // HandleMultiPart stores image from multipart form
-func (srv Service) HandleMultiPart(form *multipart.Form) (*string, error) {
+func (srv Service) HandleMultiPart(form *multipart.Form, attrs Attrs) (*string, error) {
 	files, ok := form.File["file"]
 	if !ok || len(files) != 1 {
 		return nil, NewHTTPError(
@@ -104,7 +210,9 @@ func (srv Service) HandleMultiPart(form *multipart.Form) (*string, error) {
 		)
 	}
 
-	name, err := srv.saveFile(src, contentType, fileName)
+	meta := attrs.meta()
+	meta.Size = file.Size
+	name, err := srv.saveFile(src, contentType, fileName, meta)
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +257,7 @@ func (srv Service) CheckURL(rawURL string) error {
 }
 
 // HandleURL reveives and stores image from URL
-func (srv Service) HandleURL(url string) (*string, error) {
+func (srv Service) HandleURL(url string, attrs Attrs) (*string, error) {
 	if err := srv.CheckURL(url); err != nil {
 		return nil, NewHTTPError(
 			http.StatusBadRequest,
@@ -177,7 +285,9 @@ func (srv Service) HandleURL(url string) (*string, error) {
 		)
 	}
 
-	name, err := srv.saveFile(src, contentType, fileName)
+	meta := attrs.meta()
+	meta.Size = max(response.ContentLength, 0)
+	name, err := srv.saveFile(src, contentType, fileName, meta)
 	if err != nil {
 		return nil, err
 	}
@@ -185,7 +295,7 @@ func (srv Service) HandleURL(url string) (*string, error) {
 }
 
 // HandleBase64 stores file received as base64 encoded string
-func (srv Service) HandleBase64(data, name string) (*string, error) {
+func (srv Service) HandleBase64(data, name string, attrs Attrs) (*string, error) {
 	prefixLen := strings.Index(data, ",")
 	if prefixLen < Base64MinCommaIndex {
 		return nil, NewHTTPError(
@@ -205,22 +315,83 @@ func (srv Service) HandleBase64(data, name string) (*string, error) {
 		return nil, NewHTTPError(http.StatusBadRequest, err)
 	}
 	src := bytes.NewReader(file)
-	name, err = srv.saveFile(src, contentType, name)
+	meta := attrs.meta()
+	meta.Size = int64(len(file))
+	name, err = srv.saveFile(src, contentType, name, meta)
 	if err != nil {
 		return nil, err
 	}
 	return &name, nil
 }
 
-// saveFile saves file from src and also creates preview for it
-func (srv Service) saveFile(src io.Reader, contentType, fileName string) (name string, err error) {
+// saveFile saves file from src and also creates preview for it.
+// Metadata fields filled in meta by caller are stored as is,
+// meta.Size holds expected file size (0 if unknown) used for quota check.
+func (srv Service) saveFile(src io.Reader, contentType, fileName string, meta *Meta) (name string, err error) {
 	cfg := srv.Config
+	useRandom := cfg.UseRandomName
+	var limits []*sizeReader
+	if c := meta.constraints; c != nil {
+		if fileName, err = c.check(contentType, fileName, meta.Size); err != nil {
+			return
+		}
+		if c.Name != "" {
+			useRandom = false
+		}
+		if c.MaxSize > 0 {
+			limited := &sizeReader{r: src, max: c.MaxSize, err: NewHTTPError(http.StatusRequestEntityTooLarge, errors.New(ErrConstraintSize))}
+			limits = append(limits, limited)
+			src = limited
+		}
+	}
+	commit, left, err := srv.reserveQuota(meta, meta.Size)
+	if err != nil {
+		return
+	}
+	if left >= 0 {
+		// size may be unknown before reading, so quota is checked while reading too
+		limited := &sizeReader{r: src, max: left, err: NewHTTPError(http.StatusRequestEntityTooLarge, errors.New(ErrQuotaTooLarge))}
+		limits = append(limits, limited)
+		src = limited
+	}
+	defer func() {
+		if err != nil {
+			commit(-1)
+			return
+		}
+		commit(meta.Size)
+	}()
+	if cfg.ClamdAddr != "" {
+		// file becomes available in public dir after scan only
+		var scanned *os.File
+		if scanned, err = srv.scan(src); err != nil {
+			return
+		}
+		defer removeTemp(scanned)
+		src = scanned
+	}
+	if meta.Profile == "" {
+		meta.Profile = cfg.Profile
+	}
+	if meta.Profile == ProfileAvatar {
+		if src, fileName, err = srv.avatar(src, contentType, fileName); err != nil {
+			if e := sizeError(limits); e != nil {
+				err = e
+			}
+			return
+		}
+	} else {
+		// default profile is not stored
+		meta.Profile = ""
+	}
 
-	dst, err := createFile(cfg.UseRandomName, cfg.Dir, contentType, fileName)
+	ownerDir := OwnerDir(meta.Owner)
+	dir := filepath.Join(cfg.Dir, ownerDir)
+	dst, err := createFile(useRandom, dir, contentType, fileName)
 	defer func() {
 		if err != nil {
 			// remove image random dir if was created
-			if dst != nil && path.Dir(dst.Name()) != cfg.Dir {
+			if dst != nil && path.Dir(dst.Name()) != dir {
 				e := os.Remove(path.Dir(dst.Name()))
 				if e != nil {
 					srv.Log.Errorf("Error removing file: ", e)
@@ -243,7 +414,16 @@ func (srv Service) saveFile(src io.Reader, contentType, fileName string) (name s
 
 	var cnt int64
 	srcName := dst.Name()
-	if cnt, err = io.Copy(dst, src); err != nil {
+	if cfg.SVG && isSVG(srcName) {
+		cnt, err = sanitizeSVG(dst, src)
+	} else {
+		cnt, err = io.Copy(dst, src)
+	}
+	if err != nil {
+		if cfg.SVG && isSVG(srcName) && sizeError(limits) == nil {
+			srv.Log.Warnf("SVG error: %v", err)
+			err = NewHTTPError(http.StatusUnsupportedMediaType, errors.New(ErrNotImage))
+		}
 		return
 	}
 	if err = dst.Close(); err != nil {
@@ -252,16 +432,40 @@ func (srv Service) saveFile(src io.Reader, contentType, fileName string) (name s
 
 	// create preview
 	var img image.Image
-	img, err = imgconv.Open(srcName)
+	img, err = srv.openImage(srcName)
 	if err != nil {
 		// File is not an image
 		srv.Log.Warnf("Open error: %v", err)
 		err = NewHTTPError(http.StatusUnsupportedMediaType, errors.New(ErrNotImage))
 		return
 	}
+	if c := meta.constraints; c != nil {
+		// name or content type may not match image data
+		if err = c.checkFormat(srv.imageFormat(srcName)); err != nil {
+			return
+		}
+	}
+	if profile := srv.iccOf(srcName); profile != nil {
+		meta.ICC = profile.desc
+		if cfg.ICCOriginal == ICCOriginalConvert && !profile.isSRGB() {
+			// img is converted already, store it without profile
+			if err = writeImage(srcName, img); err != nil {
+				return
+			}
+			var fi os.FileInfo
+			if fi, err = os.Stat(srcName); err != nil {
+				return
+			}
+			cnt = fi.Size()
+		}
+	}
 	name = strings.TrimPrefix(srcName, cfg.Dir)
-	previewName := filepath.Join(cfg.PreviewDir, name)
+	previewName := filepath.Join(cfg.PreviewDir, RasterName(name))
 	previewImage := imgconv.Resize(img, &imgconv.ResizeOption{Width: cfg.PreviewWidth, Height: cfg.PreviewHeight})
+	mark := srv.watermarkOn(WatermarkPreview)
+	if mark != nil {
+		previewImage = mark.apply(previewImage)
+	}
 
 	// name may contains random dir, ensure dir exists anyway
 	err = os.MkdirAll(path.Dir(previewName), 0700)
@@ -271,7 +475,7 @@ func (srv Service) saveFile(src io.Reader, contentType, fileName string) (name s
 	defer func() {
 		if err != nil {
 			// remove preview random dir if created
-			if path.Dir(previewName) != cfg.PreviewDir {
+			if path.Dir(previewName) != filepath.Join(cfg.PreviewDir, ownerDir) {
 				e := os.Remove(path.Dir(previewName))
 				if e != nil {
 					srv.Log.Errorf("Error removing preview dir: %w", e)
@@ -279,24 +483,111 @@ func (srv Service) saveFile(src io.Reader, contentType, fileName string) (name s
 			}
 		}
 	}()
-	// open output file
-	var fo *os.File
-	fo, err = os.Create(previewName) // #nosec G304, checked via ReImageFileName.MatchString
+	var frames int
+	if frames, err = countFrames(srcName); err != nil {
+		return
+	}
+	if mark == nil && srv.useAnimation(frames, previewName) {
+		// animated previews are not watermarked
+		err = writeAnimation(srcName, previewName, cfg.PreviewWidth, cfg.PreviewHeight)
+	} else {
+		err = writeImage(previewName, previewImage, srv.encodeOptions(PresetPreview)...)
+	}
 	if err != nil {
-		srv.Log.Errorf("Create error: %w", err)
+		srv.Log.Errorf("Create error: %v", err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			// remove preview if metadata was not saved
+			e := os.Remove(previewName)
+			if e != nil {
+				srv.Log.Errorf("Error removing preview: %v", e)
+			}
+		}
+	}()
+	srv.Log.Infof("Saved %d of %s", cnt, srcName)
+	meta.Name = name
+	meta.Size = cnt
+	meta.Width = img.Bounds().Dx()
+	meta.Height = img.Bounds().Dy()
+	meta.Created = time.Now()
+	meta.PHash = formatHash(dHash(img))
+	if mark != nil {
+		meta.Watermark = mark.key
+	}
+	if frames > 1 {
+		meta.Animated = true
+		meta.Frames = frames
+	}
+	var pages int
+	if pages, err = pageCount(srcName); err != nil {
+		return
+	}
+	if pages > 1 {
+		meta.Pages = pages
+	}
+	if meta.BlurHash, meta.LQIP, err = srv.placeholders(img); err != nil {
+		return
+	}
+	meta.Color, meta.Palette = extractPalette(img, cfg.PaletteSize)
+	meta.SrcsetWidths = srv.srcsetWidths(meta.Width, meta.Profile)
+	var variants []string
+	if variants, err = srv.buildSrcset(name, img, meta.SrcsetWidths); err != nil {
 		return
 	}
-	// close fo on exit and check for its returned error
 	defer func() {
-		err = fo.Close()
+		if err != nil {
+			// remove srcset copies if metadata was not saved
+			srv.removeVariants(variants)
+		}
 	}()
-	ext := path.Ext(previewName)
+	meta.Tiles = srv.useTiles(img)
+	if err = srv.saveMeta(meta); err != nil {
+		return
+	}
+	if meta.Tiles {
+		srv.buildTilesAsync(name, img)
+	}
+	return
+}
+
+// useAnimation checks if animated preview should be created
+func (srv Service) useAnimation(frames int, previewName string) bool {
+	cfg := srv.Config
+	if frames < 2 || cfg.Animation != AnimationAnimated || strings.ToLower(path.Ext(previewName)) != ".gif" {
+		return false
+	}
+	if frames > cfg.MaxFrames {
+		srv.Log.Warnf("Animation of %s has too many frames (%d), static preview will be used", previewName, frames)
+		return false
+	}
+	return true
+}
+
+// writeImage saves img to file in format defined by file ext
+func writeImage(file string, img image.Image, opts ...imgconv.EncodeOption) (err error) {
+	ext := path.Ext(file)
+	if ext == "" {
+		return image.ErrFormat
+	}
 	var format imgconv.Format
 	if format, err = imgconv.FormatFromExtension(ext[1:]); err != nil {
 		return
 	}
-	err = imgconv.Write(fo, previewImage, &imgconv.FormatOption{Format: format}) // file mode allows read for all
-	srv.Log.Infof("Saved %d of %s", cnt, srcName)
+	// open output file
+	var fo *os.File
+	fo, err = os.Create(file) // #nosec G304, checked via ReImageFileName.MatchString
+	if err != nil {
+		return
+	}
+	// close fo on exit and check for its returned error
+	defer func() {
+		if e := fo.Close(); err == nil {
+			err = e
+		}
+	}()
+	err = imgconv.Write(fo, img, &imgconv.FormatOption{Format: format, EncodeOption: opts}) // file mode allows read for all
 	return
 }
 
@@ -2,6 +2,7 @@ package upload
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	mapper "github.com/birkirb/loggers-mapper-logrus"
@@ -53,8 +55,13 @@ func (ss *ServerSuite) SetupSuite() {
 	require.NoError(ss.T(), err)
 	ss.cfg.Dir = filepath.Join(ss.root, "/img")
 	ss.cfg.PreviewDir = filepath.Join(ss.root, "/preview")
+	ss.cfg.MetaDir = filepath.Join(ss.root, "/meta")
+	ss.cfg.VariantDir = filepath.Join(ss.root, "/variant")
+	ss.cfg.TileDir = filepath.Join(ss.root, "/tiles")
 	ss.cfg.AllowedImageHosts = []string{"127.0.0.1"}
-	ss.srv = New(ss.cfg, log)
+	ss.cfg.VariantSizes = []string{"10x0", "50x0", "60x0"}
+	ss.srv, err = New(ss.cfg, log)
+	require.NoError(ss.T(), err)
 }
 
 func (ss *ServerSuite) TearDownSuite() {
@@ -90,14 +97,14 @@ func (ss *ServerSuite) TestHandleMultiPart() {
 		req.Header.Set("Content-Type", writer.FormDataContentType())
 		err = req.ParseMultipartForm(32 << 20)
 		require.NoError(ss.T(), err)
-		_, err = ss.srv.HandleMultiPart(req.MultipartForm)
+		_, err = ss.srv.HandleMultiPart(req.MultipartForm, Attrs{})
 		if tt.err != nil {
 			require.NotNil(ss.T(), err, tt.name)
 			assert.Equal(ss.T(), tt.err.Error(), err.Error(), tt.name)
 			continue
 		}
 		require.NoError(ss.T(), err, tt.name)
-		name, err := ss.srv.HandleMultiPart(req.MultipartForm)
+		name, err := ss.srv.HandleMultiPart(req.MultipartForm, Attrs{})
 		require.NoError(ss.T(), err, tt.name)
 		cmp := equalfile.New(nil, equalfile.Options{}) // compare using single mode
 		equal, err := cmp.CompareFile("../testdata/pic100.jpg", ss.root+"/preview"+*name)
@@ -108,7 +115,7 @@ func (ss *ServerSuite) TestHandleMultiPart() {
 
 func (ss *ServerSuite) TestHandleBase64BadRequest() {
 	ss.hook.Reset()
-	_, err := ss.srv.HandleBase64(badBase64, "file.png")
+	_, err := ss.srv.HandleBase64(badBase64, "file.png", Attrs{})
 	ss.printLogs()
 	require.NotNil(ss.T(), err)
 	httpErr, ok := err.(interface{ Status() int })
@@ -118,7 +125,7 @@ func (ss *ServerSuite) TestHandleBase64BadRequest() {
 
 func (ss *ServerSuite) TestHandleBase64BadPrefix() {
 	ss.hook.Reset()
-	_, err := ss.srv.HandleBase64(badBase64Prefix, "file.png")
+	_, err := ss.srv.HandleBase64(badBase64Prefix, "file.png", Attrs{})
 	ss.printLogs()
 	require.NotNil(ss.T(), err)
 	httpErr, ok := err.(interface{ Status() int })
@@ -136,7 +143,7 @@ func (ss *ServerSuite) TestHandleBase64OK() {
 	ss.hook.Reset()
 	js := &File{}
 	helperLoadJSON(ss.T(), "build", js)
-	name, err := ss.srv.HandleBase64(js.Data, js.Name)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
 	require.NoError(ss.T(), err)
 	cmp := equalfile.New(nil, equalfile.Options{}) // compare using single mode
 	equal, err := cmp.CompareFile("../testdata/build100.png", ss.root+"/preview"+*name)
@@ -148,10 +155,10 @@ func (ss *ServerSuite) TestHandleBase64NoExt() {
 	ss.hook.Reset()
 	js := &File{}
 	helperLoadJSON(ss.T(), "build", js)
-	_, err := ss.srv.HandleBase64(js.Data, "build")
+	_, err := ss.srv.HandleBase64(js.Data, "build", Attrs{})
 	require.EqualError(ss.T(), err, ErrBadFilename)
 
-	name, err := ss.srv.HandleBase64(js.Data, "build.png")
+	name, err := ss.srv.HandleBase64(js.Data, "build.png", Attrs{})
 	require.NoError(ss.T(), err)
 
 	cmp := equalfile.New(nil, equalfile.Options{}) // compare using single mode
@@ -160,7 +167,7 @@ func (ss *ServerSuite) TestHandleBase64NoExt() {
 	assert.True(ss.T(), equal)
 
 	// test same name with error
-	_, err = ss.srv.HandleBase64(badBase64Image, "build")
+	_, err = ss.srv.HandleBase64(badBase64Image, "build", Attrs{})
 	require.NotNil(ss.T(), err)
 	httpErr, ok := err.(interface{ Status() int })
 	assert.True(ss.T(), ok)
@@ -172,7 +179,7 @@ func (ss *ServerSuite) TestHandleBase64BadMedia() {
 	ss.hook.Reset()
 	js := &File{}
 	helperLoadJSON(ss.T(), "unknown", js)
-	_, err := ss.srv.HandleBase64(js.Data, js.Name)
+	_, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
 	require.NotNil(ss.T(), err)
 	httpErr, ok := err.(interface{ Status() int })
 	assert.True(ss.T(), ok)
@@ -184,7 +191,7 @@ func (ss *ServerSuite) TestHandleBase64NoExtRandom() {
 	js := &File{}
 	helperLoadJSON(ss.T(), "build", js)
 	ss.srv.Config.UseRandomName = true // TODO: This is incompartible with parallel tests
-	name, err := ss.srv.HandleBase64(js.Data, "build.png")
+	name, err := ss.srv.HandleBase64(js.Data, "build.png", Attrs{})
 	ss.srv.Config.UseRandomName = false // TODO: This is incompartible with parallel tests
 	require.NoError(ss.T(), err)
 	cmp := equalfile.New(nil, equalfile.Options{}) // compare using single mode
@@ -193,13 +200,85 @@ func (ss *ServerSuite) TestHandleBase64NoExtRandom() {
 	assert.True(ss.T(), equal)
 }
 
+func (ss *ServerSuite) TestMeta() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), *name, meta.Name)
+	assert.NotZero(ss.T(), meta.Width)
+	assert.NotZero(ss.T(), meta.Height)
+	assert.NotEmpty(ss.T(), meta.BlurHash)
+	assert.True(ss.T(), strings.HasPrefix(meta.LQIP, LQIPPrefix))
+
+	list, err := ss.srv.List(Attrs{})
+	require.NoError(ss.T(), err)
+	assert.Contains(ss.T(), list, *meta)
+
+	_, err = ss.srv.Meta("/../unknown.png")
+	require.NotNil(ss.T(), err)
+	httpErr, ok := err.(interface{ Status() int })
+	assert.True(ss.T(), ok)
+	assert.Equal(ss.T(), http.StatusNotFound, httpErr.Status())
+}
+
+func (ss *ServerSuite) TestSimilar() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	pic, err := os.ReadFile("../testdata/pic.jpg")
+	require.NoError(ss.T(), err)
+	picName, err := ss.srv.HandleBase64("data:image/jpeg;base64,"+base64.StdEncoding.EncodeToString(pic), "pic.jpg", Attrs{})
+	require.NoError(ss.T(), err)
+
+	list, err := ss.srv.Similar(*name, 0, Attrs{})
+	require.NoError(ss.T(), err)
+	for _, m := range list {
+		assert.NotEqual(ss.T(), *name, m.Name)
+		assert.NotEqual(ss.T(), *picName, m.Name)
+		assert.Equal(ss.T(), 0, m.Distance)
+	}
+
+	// search by resized copy
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "build100.png")
+	require.NoError(ss.T(), err)
+	preview, err := os.ReadFile("../testdata/build100.png")
+	require.NoError(ss.T(), err)
+	_, err = part.Write(preview)
+	require.NoError(ss.T(), err)
+	require.NoError(ss.T(), writer.Close())
+	req, _ := http.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	require.NoError(ss.T(), req.ParseMultipartForm(32<<20))
+	list, err = ss.srv.SimilarFile(req.MultipartForm, ss.cfg.SimilarDistance, Attrs{})
+	require.NoError(ss.T(), err)
+	names := []string{}
+	for _, m := range list {
+		names = append(names, m.Name)
+	}
+	assert.Contains(ss.T(), names, *name)
+	assert.NotContains(ss.T(), names, *picName)
+
+	_, err = ss.srv.Similar(*name, 65, Attrs{})
+	require.NotNil(ss.T(), err)
+	httpErr, ok := err.(interface{ Status() int })
+	assert.True(ss.T(), ok)
+	assert.Equal(ss.T(), http.StatusBadRequest, httpErr.Status())
+}
+
 func (ss *ServerSuite) TestHandleURLOK() {
 	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		http.ServeFile(res, req, "../testdata/build.png")
 	}))
 	defer func() { testServer.Close() }()
 
-	name, err := ss.srv.HandleURL(testServer.URL + "/build.png")
+	name, err := ss.srv.HandleURL(testServer.URL+"/build.png", Attrs{})
 
 	require.NoError(ss.T(), err)
 	cmp := equalfile.New(nil, equalfile.Options{}) // compare using single mode
@@ -214,13 +293,13 @@ func (ss *ServerSuite) TestHandleURLNotFound() {
 	}))
 	defer func() { testServer.Close() }()
 
-	_, err := ss.srv.HandleURL(testServer.URL + "/build.png")
+	_, err := ss.srv.HandleURL(testServer.URL+"/build.png", Attrs{})
 	require.NotNil(ss.T(), err)
 	assert.Equal(ss.T(), fmt.Sprintf(ErrFmtBadDownload, 404), err.Error())
 }
 
 func (ss *ServerSuite) TestHandleURLNotAvailable() {
-	_, err := ss.srv.HandleURL("http://127.0.0.1:1/build.png")
+	_, err := ss.srv.HandleURL("http://127.0.0.1:1/build.png", Attrs{})
 	require.NotNil(ss.T(), err)
 
 	httpErr, ok := err.(interface{ Status() int })
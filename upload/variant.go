@@ -0,0 +1,198 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sunshineplan/imgconv"
+)
+
+const (
+	// ErrBadSize returned when requested variant size is out of range
+	ErrBadSize = "variant size out of range"
+	// ErrSizeNotAllowed returned when requested variant size is not in allowed list
+	ErrSizeNotAllowed = "variant size is not allowed"
+
+	// variantCacheLow holds cache size after prune (% of VariantCacheSize), so next writes do not prune again
+	variantCacheLow = 90
+)
+
+// variantCache holds size of on-demand variants cache
+type variantCache struct {
+	mu     sync.Mutex
+	loaded bool
+	size   int64
+}
+
+// Variant returns filename of stored image copy resized to given size.
+// Zero width or height keeps aspect ratio. Allowed sizes are image srcset widths and VariantSizes.
+// Variant is created on first request.
+func (srv Service) Variant(name string, width, height int) (string, error) {
+	cfg := srv.Config
+	if width < 0 || height < 0 || width+height == 0 || width > cfg.VariantMaxSize || height > cfg.VariantMaxSize {
+		return "", NewHTTPError(http.StatusBadRequest, errors.New(ErrBadSize))
+	}
+	meta, err := srv.Meta(name)
+	if err != nil {
+		return "", err
+	}
+	if !(height == 0 && slices.Contains(meta.SrcsetWidths, width)) &&
+		!slices.Contains(cfg.VariantSizes, fmt.Sprintf("%dx%d", width, height)) {
+		return "", NewHTTPError(http.StatusBadRequest, errors.New(ErrSizeNotAllowed))
+	}
+	return srv.variant(name, nil, width, height)
+}
+
+// variant returns filename of stored image copy resized to given size, creating it if needed.
+// img holds decoded stored image, it is loaded from file if nil.
+func (srv Service) variant(name string, img image.Image, width, height int) (string, error) {
+	mark := srv.watermarkOn(WatermarkVariant)
+	// cache key must change when variant image changes
+	key := fmt.Sprintf("%dx%d", width, height)
+	if mark != nil {
+		key += "-" + mark.key
+	}
+	file := srv.variantFile(key, name)
+	if cachedVariant(file) {
+		return file, nil
+	}
+
+	if img == nil {
+		var err error
+		if img, err = srv.openOriginal(name); err != nil {
+			return "", err
+		}
+	}
+	img = imgconv.Resize(img, &imgconv.ResizeOption{Width: width, Height: height})
+	if mark != nil {
+		img = mark.apply(img)
+	}
+	err := srv.saveVariant(file, func(tmp string) error {
+		return writeImage(tmp, img, srv.encodeOptions(PresetVariant)...)
+	})
+	if err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// variantFile returns cached variant filename of image name for cache key
+func (srv Service) variantFile(key, name string) string {
+	return filepath.Join(srv.Config.VariantDir, key, RasterName(path.Clean("/"+name)))
+}
+
+// cachedVariant checks if variant file exists and marks it as used
+func cachedVariant(file string) bool {
+	if _, err := os.Stat(file); err != nil {
+		return false
+	}
+	// modification time of cached variant is its last use time
+	now := time.Now()
+	os.Chtimes(file, now, now) // nolint: errcheck
+	return true
+}
+
+// saveVariant creates variant file by write func and adds it to cache.
+// File is written to temp file first, so concurrent requests never get partial variant.
+func (srv Service) saveVariant(file string, write func(tmp string) error) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "*"+path.Ext(file))
+	if err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	var info os.FileInfo
+	if err = write(tmp.Name()); err == nil {
+		if info, err = os.Stat(tmp.Name()); err == nil {
+			err = os.Rename(tmp.Name(), file)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name()) // nolint: errcheck
+		return err
+	}
+	if err = srv.addVariant(info.Size()); err != nil {
+		srv.Log.Warnf("Variant cache prune error: %v", err)
+	}
+	return nil
+}
+
+// addVariant counts size of written variant and prunes cache when it exceeds VariantCacheSize.
+// Cache dir is walked on first call and on prune only, removed variants of deleted images
+// are not counted until then.
+func (srv Service) addVariant(size int64) error {
+	limit := srv.Config.VariantCacheSize << 20
+	if limit <= 0 {
+		return nil
+	}
+	cache := srv.variantCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.loaded {
+		cache.size += size
+		if cache.size <= limit {
+			return nil
+		}
+		limit = limit * variantCacheLow / 100
+	}
+	total, err := srv.pruneVariants(limit)
+	if err != nil {
+		return err
+	}
+	cache.size, cache.loaded = total, true
+	return nil
+}
+
+// pruneVariants removes least recently used variants until cache size fits limit.
+// Returns cache size after prune.
+func (srv Service) pruneVariants(limit int64) (int64, error) {
+	type cached struct {
+		file string
+		size int64
+		used time.Time
+	}
+	var (
+		files []cached
+		total int64
+	)
+	err := filepath.WalkDir(srv.Config.VariantDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			// removed by concurrent prune
+			return nil
+		}
+		files = append(files, cached{file, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil || total <= limit {
+		return total, err
+	}
+	slices.SortFunc(files, func(a, b cached) int { return a.used.Compare(b.used) })
+	for _, f := range files {
+		if total <= limit {
+			break
+		}
+		if err = os.Remove(f.file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return total, err
+		}
+		total -= f.size
+	}
+	return total, nil
+}
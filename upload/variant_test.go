@@ -0,0 +1,68 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// helperVariants writes variant files of size given, each next file is used a minute later
+func helperVariants(t *testing.T, dir string, size int, used time.Time, keys ...string) []string {
+	var files []string
+	for i, key := range keys {
+		file := filepath.Join(dir, key, "file.png")
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0750))
+		require.NoError(t, os.WriteFile(file, make([]byte, size), 0600))
+		used := used.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(file, used, used))
+		files = append(files, file)
+	}
+	return files
+}
+
+func TestPruneVariants(t *testing.T) {
+	srv := Service{Config: &Config{VariantDir: t.TempDir()}}
+	now := time.Now()
+	files := helperVariants(t, srv.Config.VariantDir, 256<<10, now.Add(-time.Hour), "10x0", "20x0", "30x0", "40x0")
+	// last used variant is kept even if it is older by name
+	require.NoError(t, os.Chtimes(files[0], now, now))
+
+	total, err := srv.pruneVariants(512 << 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(512<<10), total)
+	for i, exists := range []bool{true, false, false, true} {
+		_, err := os.Stat(files[i])
+		assert.Equal(t, exists, err == nil, "least recently used variants are removed first: %d", i)
+	}
+}
+
+func TestAddVariant(t *testing.T) {
+	srv := Service{Config: &Config{VariantDir: t.TempDir(), VariantCacheSize: 1}, variantCache: &variantCache{}}
+	used := time.Now().Add(-time.Hour)
+	files := helperVariants(t, srv.Config.VariantDir, 256<<10, used, "10x0", "20x0")
+
+	// cache size is loaded from dir on first call
+	require.NoError(t, srv.addVariant(256<<10))
+	assert.Equal(t, int64(512<<10), srv.variantCache.size)
+
+	// cache dir is not walked until cache is full
+	files = append(files, helperVariants(t, srv.Config.VariantDir, 256<<10, used.Add(time.Minute*2), "30x0", "40x0", "50x0")...)
+	require.NoError(t, srv.addVariant(256<<10))
+	require.NoError(t, srv.addVariant(256<<10))
+	assert.Equal(t, int64(1<<20), srv.variantCache.size)
+	for _, file := range files {
+		assert.FileExists(t, file)
+	}
+
+	// full cache is pruned below limit
+	require.NoError(t, srv.addVariant(256<<10))
+	assert.Equal(t, int64(768<<10), srv.variantCache.size)
+	for i, exists := range []bool{false, false, true, true, true} {
+		_, err := os.Stat(files[i])
+		assert.Equal(t, exists, err == nil, i)
+	}
+}
@@ -0,0 +1,135 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"slices"
+
+	"github.com/sunshineplan/imgconv"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// WatermarkPreview means watermark is applied to previews
+	WatermarkPreview = "preview"
+	// WatermarkVariant means watermark is applied to on-demand variants
+	WatermarkVariant = "variant"
+
+	// ErrWatermarkOpacity returned when watermark opacity is out of range
+	ErrWatermarkOpacity = "watermark opacity must be in range 0..100"
+	// ErrWatermarkScale returned when watermark scale is out of range
+	ErrWatermarkScale = "watermark scale must be in range 0..1"
+
+	// watermarkMargin holds watermark distance from image border (px)
+	watermarkMargin = 4
+	// watermarkKeyLen holds length of watermark config hash used in cache keys
+	watermarkKeyLen = 8
+)
+
+// watermark holds loaded watermark image and its placement options
+type watermark struct {
+	mark     image.Image
+	position string
+	opacity  uint8
+	scale    float64
+	key      string // watermark config hash
+}
+
+// newWatermark loads watermark from config, returns nil if watermark is not configured
+func newWatermark(cfg *Config) (*watermark, error) {
+	if cfg.Watermark == "" && cfg.WatermarkText == "" {
+		return nil, nil
+	}
+	if cfg.WatermarkOpacity < 0 || cfg.WatermarkOpacity > 100 {
+		return nil, errors.New(ErrWatermarkOpacity)
+	}
+	if cfg.WatermarkScale <= 0 || cfg.WatermarkScale > 1 {
+		return nil, errors.New(ErrWatermarkScale)
+	}
+	hash := sha256.New()
+	var mark image.Image
+	if cfg.Watermark != "" {
+		data, err := os.ReadFile(cfg.Watermark)
+		if err != nil {
+			return nil, err
+		}
+		hash.Write(data)
+		if mark, err = imgconv.Open(cfg.Watermark); err != nil {
+			return nil, err
+		}
+	} else {
+		hash.Write([]byte(cfg.WatermarkText))
+		mark = textImage(cfg.WatermarkText)
+	}
+	fmt.Fprintf(hash, "|%s|%d|%g", cfg.WatermarkPosition, cfg.WatermarkOpacity, cfg.WatermarkScale)
+	return &watermark{
+		mark:     mark,
+		position: cfg.WatermarkPosition,
+		opacity:  uint8(cfg.WatermarkOpacity * 255 / 100),
+		scale:    cfg.WatermarkScale,
+		key:      fmt.Sprintf("%x", hash.Sum(nil))[:watermarkKeyLen],
+	}, nil
+}
+
+// apply returns copy of img with watermark
+func (w *watermark) apply(img image.Image) image.Image {
+	b := img.Bounds()
+	rv := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rv, rv.Bounds(), img, b.Min, draw.Src)
+
+	width := int(float64(b.Dx()) * w.scale)
+	if width < 1 {
+		return rv
+	}
+	mark := imgconv.Resize(w.mark, &imgconv.ResizeOption{Width: width})
+	size := mark.Bounds().Size()
+	var pt image.Point
+	switch w.position {
+	case "top-left":
+		pt = image.Pt(watermarkMargin, watermarkMargin)
+	case "top-right":
+		pt = image.Pt(b.Dx()-size.X-watermarkMargin, watermarkMargin)
+	case "bottom-left":
+		pt = image.Pt(watermarkMargin, b.Dy()-size.Y-watermarkMargin)
+	case "center":
+		pt = image.Pt((b.Dx()-size.X)/2, (b.Dy()-size.Y)/2)
+	default: // bottom-right
+		pt = image.Pt(b.Dx()-size.X-watermarkMargin, b.Dy()-size.Y-watermarkMargin)
+	}
+	draw.DrawMask(rv, image.Rectangle{pt, pt.Add(size)}, mark, mark.Bounds().Min,
+		image.NewUniform(color.Alpha{w.opacity}), image.Point{}, draw.Over)
+	return rv
+}
+
+// textImage renders text into image with transparent background
+func textImage(text string) image.Image {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil()
+	img := image.NewNRGBA(image.Rect(0, 0, width+1, face.Height+1))
+	// shadow makes text readable on light images
+	for i, c := range []color.Color{color.Black, color.White} {
+		d := font.Drawer{
+			Dst:  img,
+			Src:  image.NewUniform(c),
+			Face: face,
+			Dot:  fixed.P(1-i, face.Ascent+1-i),
+		}
+		d.DrawString(text)
+	}
+	return img
+}
+
+// watermarkOn returns watermark if it must be applied to image kind given
+func (srv Service) watermarkOn(kind string) *watermark {
+	if srv.mark == nil || !slices.Contains(srv.Config.WatermarkOn, kind) {
+		return nil
+	}
+	return srv.mark
+}
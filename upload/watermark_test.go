@@ -0,0 +1,119 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sunshineplan/imgconv"
+)
+
+func (ss *ServerSuite) TestVariant() {
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+
+	file, err := ss.srv.Variant(*name, 50, 0)
+	require.NoError(ss.T(), err)
+	img, err := imgconv.Open(file)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), 50, img.Bounds().Dx())
+	cached, err := ss.srv.Variant(*name, 50, 0)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), file, cached)
+
+	// watermark config is a part of cache key
+	mark, err := newWatermark(&Config{WatermarkText: "fiwes", WatermarkOpacity: 50, WatermarkScale: 0.5})
+	require.NoError(ss.T(), err)
+	ss.srv.mark = mark
+	defer func() { ss.srv.mark = nil }()
+	marked, err := ss.srv.Variant(*name, 50, 0)
+	require.NoError(ss.T(), err)
+	assert.NotEqual(ss.T(), file, marked)
+	assert.True(ss.T(), strings.Contains(marked, "50x0-"+mark.key))
+
+	tests := []struct {
+		name   string
+		file   string
+		width  int
+		height int
+		status int
+	}{
+		{"NoSize", *name, 0, 0, 400},
+		{"TooLarge", *name, ss.cfg.VariantMaxSize + 1, 0, 400},
+		{"NotAllowed", *name, 51, 0, 400},
+		{"NotFound", "/../unknown.png", 10, 10, 404},
+	}
+	for _, tt := range tests {
+		_, err := ss.srv.Variant(tt.file, tt.width, tt.height)
+		require.NotNil(ss.T(), err, tt.name)
+		httpErr, ok := err.(interface{ Status() int })
+		assert.True(ss.T(), ok, tt.name)
+		assert.Equal(ss.T(), tt.status, httpErr.Status(), tt.name)
+	}
+}
+
+func (ss *ServerSuite) TestWatermarkPreview() {
+	mark, err := newWatermark(&Config{WatermarkText: "fiwes", WatermarkOpacity: 50, WatermarkScale: 0.5})
+	require.NoError(ss.T(), err)
+	ss.srv.mark = mark
+	ss.srv.Config.WatermarkOn = []string{WatermarkPreview}
+	defer func() {
+		ss.srv.mark = nil
+		ss.srv.Config.WatermarkOn = ss.cfg.WatermarkOn
+	}()
+	js := &File{}
+	helperLoadJSON(ss.T(), "build", js)
+	name, err := ss.srv.HandleBase64(js.Data, js.Name, Attrs{})
+	require.NoError(ss.T(), err)
+	meta, err := ss.srv.Meta(*name)
+	require.NoError(ss.T(), err)
+	assert.Equal(ss.T(), mark.key, meta.Watermark)
+}
+
+func TestNewWatermark(t *testing.T) {
+	mark, err := newWatermark(&Config{})
+	require.NoError(t, err)
+	assert.Nil(t, mark)
+
+	_, err = newWatermark(&Config{WatermarkText: "x", WatermarkOpacity: 101, WatermarkScale: 0.5})
+	assert.EqualError(t, err, ErrWatermarkOpacity)
+	_, err = newWatermark(&Config{WatermarkText: "x", WatermarkOpacity: 50, WatermarkScale: 2})
+	assert.EqualError(t, err, ErrWatermarkScale)
+	_, err = newWatermark(&Config{Watermark: "../testdata/unknown.png", WatermarkOpacity: 50, WatermarkScale: 0.5})
+	assert.NotNil(t, err)
+	// bad watermark config must not be ignored
+	_, err = New(Config{Watermark: "../testdata/unknown.png", WatermarkOpacity: 50, WatermarkScale: 0.5, TileSize: 254}, nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	cfg := &Config{Watermark: "../testdata/build100.png", WatermarkOpacity: 50, WatermarkScale: 0.5, WatermarkPosition: "center"}
+	mark, err = newWatermark(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, watermarkKeyLen, len(mark.key))
+	cfg.WatermarkPosition = "top-left"
+	other, err := newWatermark(cfg)
+	require.NoError(t, err)
+	assert.NotEqual(t, mark.key, other.key)
+}
+
+func TestWatermarkApply(t *testing.T) {
+	base := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(base, base.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	for _, pos := range []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"} {
+		cfg := &Config{WatermarkText: "fiwes", WatermarkOpacity: 100, WatermarkScale: 0.5, WatermarkPosition: pos}
+		mark, err := newWatermark(cfg)
+		require.NoError(t, err, pos)
+		img := mark.apply(base)
+		assert.Equal(t, base.Bounds(), img.Bounds(), pos)
+		assert.NotEqual(t, base, img, pos)
+	}
+	// original image is not changed
+	r, g, b, _ := base.At(50, 50).RGBA()
+	assert.Equal(t, []uint32{0, 0, 0}, []uint32{r, g, b})
+}